@@ -0,0 +1,252 @@
+package addon
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/SchumacherFM/prometheus_shelly_exporter/collector"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/tidwall/gjson"
+	"go.uber.org/zap"
+)
+
+// Gen1 Shelly 1/1PM with the temperature add-on (DS18B20 or DHT22) and the
+// Shelly Uni publish one topic per probe and channel:
+//
+//	shellies/<id>/ext_temperature/<N>    °C
+//	shellies/<id>/ext_temperature_f/<N>  °F
+//	shellies/<id>/ext_humidity/<N>       %
+//	shellies/<id>/ext_switch/<N>         0|1
+//	shellies/<id>/adc/<N>                V
+//
+// The inputs of the Uni are left to the input collector. The hardware ID of a
+// probe is only part of shellies/<id>/info in ext_temperature.<N>.hwID and
+// ext_humidity.<N>.hwID, a probe gets exported once its hardware ID is known
+// so the hw_id label doesn't change.
+
+type temperature struct {
+	c, f       float64
+	hasC, hasF bool
+}
+
+type device struct {
+	temperatures map[string]*temperature // probe => °C/°F
+	humidities   map[string]float64      // probe => %
+	adcs         map[string]float64      // channel => V
+	switches     map[string]float64      // channel => 0|1
+	tmpHWIDs     map[string]string       // probe => hwID
+	humHWIDs     map[string]string       // probe => hwID
+}
+
+type Collector struct {
+	opts    Options
+	tmpDesc *prometheus.Desc
+	humDesc *prometheus.Desc
+	adcDesc *prometheus.Desc
+	swDesc  *prometheus.Desc
+	health  *collector.Health
+
+	mu      sync.Mutex
+	devices map[string]*device // device ID => state
+}
+
+type Options struct {
+	collector.Options
+	TestCB func()
+}
+
+func init() {
+	collector.Register(collector.Definition{
+		Name:          "addon",
+		Help:          "Shelly 1/1PM add-on and Shelly Uni",
+		Default:       true,
+		Subscriptions: []string{"shellies/+/ext_temperature/+", "shellies/+/ext_temperature_f/+", "shellies/+/ext_humidity/+", "shellies/+/ext_switch/+", "shellies/+/adc/+", "shellies/+/info"},
+		New: func(ctx context.Context, messageChan <-chan mqtt.Message, opts collector.Options) prometheus.Collector {
+			return NewCollector(ctx, messageChan, Options{Options: opts})
+		},
+	})
+}
+
+func NewCollector(ctx context.Context, messageChan <-chan mqtt.Message, opts Options) *Collector {
+	c := &Collector{
+		opts:    opts,
+		tmpDesc: prometheus.NewDesc("shellyaddon_temperature", "temperature of an external probe", []string{"device", "probe", "hw_id", "unit"}, nil),
+		humDesc: prometheus.NewDesc("shellyaddon_humidity", "humidity of an external probe", []string{"device", "probe", "hw_id", "unit"}, nil),
+		adcDesc: prometheus.NewDesc("shellyaddon_adc_voltage", "voltage of the analog input in Volts", []string{"device", "channel"}, nil),
+		swDesc:  prometheus.NewDesc("shellyaddon_ext_switch", "state of the digital input of the add-on", []string{"device", "channel"}, nil),
+		health:  collector.NewHealth("shellyaddon", opts.Options),
+		devices: make(map[string]*device),
+	}
+
+	go func() {
+		for {
+			select {
+			case msg, ok := <-messageChan:
+				if !ok {
+					if opts.TestCB != nil {
+						opts.TestCB()
+					}
+					return
+				}
+				if false == strings.HasPrefix(msg.Topic(), "shellies/") {
+					continue
+				}
+
+				c.handle(msg.Topic(), msg.Payload())
+
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return c
+}
+
+// device returns the state for the device ID and creates it if needed. The
+// caller must hold c.mu.
+func (c *Collector) device(devID string) *device {
+	d, ok := c.devices[devID]
+	if !ok {
+		d = &device{
+			temperatures: make(map[string]*temperature),
+			humidities:   make(map[string]float64),
+			adcs:         make(map[string]float64),
+			switches:     make(map[string]float64),
+			tmpHWIDs:     make(map[string]string),
+			humHWIDs:     make(map[string]string),
+		}
+		c.devices[devID] = d
+	}
+	return d
+}
+
+func (c *Collector) handle(topic string, payload []byte) {
+	topicPaths := strings.Split(topic, "/")
+	switch {
+	case len(topicPaths) == 3 && topicPaths[2] == "info":
+		c.handleInfo(topicPaths[1], payload)
+		return
+	case len(topicPaths) != 4:
+		return
+	}
+
+	// shellies/<id>/ext_temperature/0
+	devID, kind, index := topicPaths[1], topicPaths[2], topicPaths[3]
+	switch kind {
+	case "ext_temperature", "ext_temperature_f", "ext_humidity", "ext_switch", "adc":
+	default:
+		return
+	}
+
+	f64, err := strconv.ParseFloat(strings.TrimSpace(string(payload)), 64)
+	if err != nil {
+		c.health.ParseError(devID, topic, err)
+		return
+	}
+
+	c.opts.Log.Debug("message from mqtt",
+		zap.String("topic", topic),
+		zap.Int("length", len(payload)))
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.opts.Timestamps.Observe(devID, 0)
+	c.health.OK(devID)
+	d := c.device(devID)
+	switch kind {
+	case "ext_temperature", "ext_temperature_f":
+		t, ok := d.temperatures[index]
+		if !ok {
+			t = &temperature{}
+			d.temperatures[index] = t
+		}
+		if kind == "ext_temperature" {
+			t.c, t.hasC = f64, true
+		} else {
+			t.f, t.hasF = f64, true
+		}
+	case "ext_humidity":
+		d.humidities[index] = f64
+	case "adc":
+		d.adcs[index] = f64
+	case "ext_switch":
+		d.switches[index] = f64
+	}
+}
+
+func (c *Collector) handleInfo(devID string, payload []byte) {
+	r := gjson.ParseBytes(payload)
+	extTmp, extHum := r.Get("ext_temperature"), r.Get("ext_humidity")
+	if !extTmp.IsObject() && !extHum.IsObject() {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	d := c.device(devID)
+	extTmp.ForEach(func(probe, value gjson.Result) bool {
+		if hwID := value.Get("hwID").String(); hwID != "" {
+			d.tmpHWIDs[probe.String()] = hwID
+		}
+		return true
+	})
+	extHum.ForEach(func(probe, value gjson.Result) bool {
+		if hwID := value.Get("hwID").String(); hwID != "" {
+			d.humHWIDs[probe.String()] = hwID
+		}
+		return true
+	})
+}
+
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.tmpDesc
+	ch <- c.humDesc
+	ch <- c.adcDesc
+	ch <- c.swDesc
+	c.health.Describe(ch)
+}
+
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	c.collect(ch)
+	c.health.Collect(ch)
+}
+
+func (c *Collector) collect(ch chan<- prometheus.Metric) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for devID, d := range c.devices {
+		if c.opts.Presence.Offline(devID) {
+			continue
+		}
+		for probe, t := range d.temperatures {
+			hwID, ok := d.tmpHWIDs[probe]
+			if !ok {
+				continue
+			}
+			if t.hasC {
+				ch <- prometheus.MustNewConstMetric(c.tmpDesc, prometheus.GaugeValue, t.c, devID, probe, hwID, "c")
+			}
+			if t.hasF {
+				ch <- prometheus.MustNewConstMetric(c.tmpDesc, prometheus.GaugeValue, t.f, devID, probe, hwID, "f")
+			}
+		}
+		for probe, v := range d.humidities {
+			if hwID, ok := d.humHWIDs[probe]; ok {
+				ch <- prometheus.MustNewConstMetric(c.humDesc, prometheus.GaugeValue, v, devID, probe, hwID, "%")
+			}
+		}
+		for channel, v := range d.adcs {
+			ch <- prometheus.MustNewConstMetric(c.adcDesc, prometheus.GaugeValue, v, devID, channel)
+		}
+		for channel, v := range d.switches {
+			ch <- prometheus.MustNewConstMetric(c.swDesc, prometheus.GaugeValue, v, devID, channel)
+		}
+	}
+}
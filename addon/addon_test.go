@@ -0,0 +1,80 @@
+package addon
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/SchumacherFM/prometheus_shelly_exporter/collector"
+	"github.com/SchumacherFM/prometheus_shelly_exporter/collector/collectortest"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+var _ prometheus.Collector = (*Collector)(nil)
+
+func TestCollector_collect(t *testing.T) {
+	ctx := context.Background()
+	msgChan := make(chan mqtt.Message)
+
+	log, _ := zap.NewDevelopment(zap.Development())
+	msgGoRoutineDone := make(chan struct{})
+	c := NewCollector(ctx, msgChan, Options{
+		Options: collector.Options{Log: log},
+		TestCB: func() {
+			close(msgGoRoutineDone)
+		},
+	})
+
+	collectortest.SendFile(t, msgChan, "testdata/addon.txt")
+	close(msgChan)
+	<-msgGoRoutineDone
+
+	// the humidity n/a keeps the last value and marks the device down, the
+	// probe of the Uni waits for its hardware ID and the inputs belong to the
+	// input collector
+	err := testutil.CollectAndCompare(c, strings.NewReader(`
+# HELP shellyaddon_adc_voltage voltage of the analog input in Volts
+# TYPE shellyaddon_adc_voltage gauge
+shellyaddon_adc_voltage{channel="0",device="shellyuni-E8DB84A1B2C3"} 11.82
+# HELP shellyaddon_ext_switch state of the digital input of the add-on
+# TYPE shellyaddon_ext_switch gauge
+shellyaddon_ext_switch{channel="0",device="shelly1-98CDAC1F2A3B"} 1
+# HELP shellyaddon_humidity humidity of an external probe
+# TYPE shellyaddon_humidity gauge
+shellyaddon_humidity{device="shelly1pm-C45BBE6FDA5D",hw_id="000000000000000f",probe="0",unit="%"} 48.3
+# HELP shellyaddon_scrape_errors_total readings of the device which failed
+# TYPE shellyaddon_scrape_errors_total counter
+shellyaddon_scrape_errors_total{device="shelly1-98CDAC1F2A3B",reason="invalid_reading"} 0
+shellyaddon_scrape_errors_total{device="shelly1-98CDAC1F2A3B",reason="parse_error"} 0
+shellyaddon_scrape_errors_total{device="shelly1-98CDAC1F2A3B",reason="stale"} 0
+shellyaddon_scrape_errors_total{device="shelly1pm-C45BBE6FDA5D",reason="invalid_reading"} 0
+shellyaddon_scrape_errors_total{device="shelly1pm-C45BBE6FDA5D",reason="parse_error"} 1
+shellyaddon_scrape_errors_total{device="shelly1pm-C45BBE6FDA5D",reason="stale"} 0
+shellyaddon_scrape_errors_total{device="shellyuni-E8DB84A1B2C3",reason="invalid_reading"} 0
+shellyaddon_scrape_errors_total{device="shellyuni-E8DB84A1B2C3",reason="parse_error"} 0
+shellyaddon_scrape_errors_total{device="shellyuni-E8DB84A1B2C3",reason="stale"} 0
+# HELP shellyaddon_temperature temperature of an external probe
+# TYPE shellyaddon_temperature gauge
+shellyaddon_temperature{device="shelly1-98CDAC1F2A3B",hw_id="2882379497020381",probe="0",unit="c"} 21.5
+shellyaddon_temperature{device="shelly1-98CDAC1F2A3B",hw_id="2882379497020381",probe="0",unit="f"} 70.7
+shellyaddon_temperature{device="shelly1-98CDAC1F2A3B",hw_id="28d1a97b9a160368",probe="1",unit="c"} 8.06
+shellyaddon_temperature{device="shelly1pm-C45BBE6FDA5D",hw_id="000000000000000f",probe="0",unit="c"} 22.6
+# HELP shellyaddon_up 1 if the last reading of the device was fine
+# TYPE shellyaddon_up gauge
+shellyaddon_up{device="shelly1-98CDAC1F2A3B"} 1
+shellyaddon_up{device="shelly1pm-C45BBE6FDA5D"} 0
+shellyaddon_up{device="shellyuni-E8DB84A1B2C3"} 1
+`),
+		"shellyaddon_adc_voltage",
+		"shellyaddon_ext_switch",
+		"shellyaddon_humidity",
+		"shellyaddon_scrape_errors_total",
+		"shellyaddon_temperature",
+		"shellyaddon_up",
+	)
+	require.NoError(t, err)
+}
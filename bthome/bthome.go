@@ -0,0 +1,319 @@
+package bthome
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/tidwall/gjson"
+)
+
+// BTHome v2, see https://bthome.io/format/. The service data (UUID 0xFCD2)
+// starts with a device information byte followed by object ID/value pairs.
+
+var (
+	ErrEncrypted   = errors.New("bthome: encrypted advertisement")
+	ErrVersion     = errors.New("bthome: unsupported version")
+	ErrShortPacket = errors.New("bthome: packet too short")
+)
+
+// objectType describes how a value of an object ID is encoded.
+type objectType struct {
+	name    string
+	size    int // bytes, 0 for variable length
+	signed  bool
+	divisor float64 // raw value / divisor = value
+}
+
+var objectTypes = map[byte]objectType{
+	0x00: {"packet_id", 1, false, 1},
+	0x01: {"battery", 1, false, 1},
+	0x02: {"temperature", 2, true, 100},
+	0x03: {"humidity", 2, false, 100},
+	0x04: {"pressure", 3, false, 100},
+	0x05: {"illuminance", 3, false, 100},
+	0x06: {"mass_kg", 2, false, 100},
+	0x07: {"mass_lb", 2, false, 100},
+	0x08: {"dewpoint", 2, true, 100},
+	0x09: {"count", 1, false, 1},
+	0x0A: {"energy", 3, false, 1000},
+	0x0B: {"power", 3, false, 100},
+	0x0C: {"voltage", 2, false, 1000},
+	0x0D: {"pm2_5", 2, false, 1},
+	0x0E: {"pm10", 2, false, 1},
+	0x0F: {"generic_boolean", 1, false, 1},
+	0x10: {"power_on", 1, false, 1},
+	0x11: {"opening", 1, false, 1},
+	0x12: {"co2", 2, false, 1},
+	0x13: {"tvoc", 2, false, 1},
+	0x14: {"moisture", 2, false, 100},
+	0x15: {"battery_low", 1, false, 1},
+	0x16: {"battery_charging", 1, false, 1},
+	0x17: {"carbon_monoxide", 1, false, 1},
+	0x18: {"cold", 1, false, 1},
+	0x19: {"connectivity", 1, false, 1},
+	0x1A: {"door", 1, false, 1},
+	0x1B: {"garage_door", 1, false, 1},
+	0x1C: {"gas", 1, false, 1},
+	0x1D: {"heat", 1, false, 1},
+	0x1E: {"light", 1, false, 1},
+	0x1F: {"lock", 1, false, 1},
+	0x20: {"moisture_binary", 1, false, 1},
+	0x21: {"motion", 1, false, 1},
+	0x22: {"moving", 1, false, 1},
+	0x23: {"occupancy", 1, false, 1},
+	0x24: {"plug", 1, false, 1},
+	0x25: {"presence", 1, false, 1},
+	0x26: {"problem", 1, false, 1},
+	0x27: {"running", 1, false, 1},
+	0x28: {"safety", 1, false, 1},
+	0x29: {"smoke", 1, false, 1},
+	0x2A: {"sound", 1, false, 1},
+	0x2B: {"tamper", 1, false, 1},
+	0x2C: {"vibration", 1, false, 1},
+	0x2D: {"window", 1, false, 1},
+	0x2E: {"humidity", 1, false, 1},
+	0x2F: {"moisture", 1, false, 1},
+	0x3A: {"button", 1, false, 1},
+	0x3C: {"dimmer", 2, false, 1},
+	0x3D: {"count", 2, false, 1},
+	0x3E: {"count", 4, false, 1},
+	0x3F: {"rotation", 2, true, 10},
+	0x40: {"distance_mm", 2, false, 1},
+	0x41: {"distance_m", 2, false, 10},
+	0x42: {"duration", 3, false, 1000},
+	0x43: {"current", 2, false, 1000},
+	0x44: {"speed", 2, false, 100},
+	0x45: {"temperature", 2, true, 10},
+	0x46: {"uv_index", 1, false, 10},
+	0x47: {"volume_l", 2, false, 10},
+	0x48: {"volume_ml", 2, false, 1},
+	0x49: {"volume_flow_rate", 2, false, 1000},
+	0x4A: {"voltage", 2, false, 10},
+	0x4B: {"gas", 3, false, 1000},
+	0x4C: {"gas", 4, false, 1000},
+	0x4D: {"energy", 4, false, 1000},
+	0x4E: {"volume", 4, false, 1000},
+	0x4F: {"water", 4, false, 1000},
+	0x50: {"timestamp", 4, false, 1},
+	0x51: {"acceleration", 2, false, 1000},
+	0x52: {"gyroscope", 2, false, 1000},
+	0x53: {"text", 0, false, 1},
+	0x54: {"raw", 0, false, 1},
+	0x55: {"volume_storage", 4, false, 1000},
+	0xF0: {"device_type_id", 2, false, 1},
+	0xF1: {"firmware_version", 4, false, 1},
+	0xF2: {"firmware_version", 3, false, 1},
+}
+
+// buttonEvents maps the value of a button object to its event name.
+var buttonEvents = map[float64]string{
+	0x00: "none",
+	0x01: "press",
+	0x02: "double_press",
+	0x03: "triple_press",
+	0x04: "long_press",
+	0x05: "long_double_press",
+	0x06: "long_triple_press",
+	0x80: "hold_press",
+}
+
+// Object is one decoded measurement of an advertisement.
+type Object struct {
+	ID    byte
+	Name  string
+	Value float64
+}
+
+// Packet is a decoded BTHome advertisement. Objects keeps the order of the
+// advertisement because multi-channel devices, like the BLU RC Button 4,
+// repeat the same object ID once per channel.
+type Packet struct {
+	Version      int
+	Encrypted    bool
+	TriggerBased bool
+	Objects      []Object
+}
+
+// Value returns the first object with the name.
+func (p Packet) Value(name string) (float64, bool) {
+	for _, o := range p.Objects {
+		if o.Name == name {
+			return o.Value, true
+		}
+	}
+	return 0, false
+}
+
+// Values returns all objects with the name in order of the advertisement.
+func (p Packet) Values(name string) []float64 {
+	var values []float64
+	for _, o := range p.Objects {
+		if o.Name == name {
+			values = append(values, o.Value)
+		}
+	}
+	return values
+}
+
+// ButtonEvent returns the name of a button object value, e.g. double_press.
+func ButtonEvent(v float64) string {
+	if name, ok := buttonEvents[v]; ok {
+		return name
+	}
+	return "unknown"
+}
+
+// Decode decodes the BTHome v2 service data. Encrypted advertisements return
+// ErrEncrypted as the bind key isn't known to the exporter.
+func Decode(data []byte) (Packet, error) {
+	if len(data) < 1 {
+		return Packet{}, ErrShortPacket
+	}
+	p := Packet{
+		Encrypted:    data[0]&0x01 != 0,
+		TriggerBased: data[0]&0x04 != 0,
+		Version:      int(data[0] >> 5),
+	}
+	if p.Version != 2 {
+		return p, fmt.Errorf("%w: %d", ErrVersion, p.Version)
+	}
+	if p.Encrypted {
+		return p, ErrEncrypted
+	}
+
+	for i := 1; i < len(data); {
+		id := data[i]
+		ot, ok := objectTypes[id]
+		if !ok {
+			return p, fmt.Errorf("bthome: unknown object ID 0x%02X at offset %d", id, i)
+		}
+		i++
+		size := ot.size
+		if size == 0 {
+			if i >= len(data) {
+				return p, ErrShortPacket
+			}
+			size = int(data[i]) + 1 // length byte plus content
+		}
+		if i+size > len(data) {
+			return p, fmt.Errorf("%w: object 0x%02X needs %d bytes at offset %d", ErrShortPacket, id, size, i)
+		}
+		raw := data[i : i+size]
+		i += size
+
+		if ot.size == 0 {
+			// text and raw have no numeric value
+			continue
+		}
+		p.Objects = append(p.Objects, Object{
+			ID:    id,
+			Name:  ot.name,
+			Value: decodeValue(raw, ot),
+		})
+	}
+	return p, nil
+}
+
+func decodeValue(raw []byte, ot objectType) float64 {
+	if ot.name == "dimmer" {
+		// event type, the number of steps is lost
+		raw = raw[:1]
+	}
+	var buf [8]byte
+	copy(buf[:], raw) // little endian
+	u := binary.LittleEndian.Uint64(buf[:])
+	if !ot.signed {
+		return float64(u) / ot.divisor
+	}
+	shift := 64 - 8*len(raw)
+	return float64(int64(u<<shift)>>shift) / ot.divisor
+}
+
+// decodedNames maps the keys of the shelly-blu event emitted by the official
+// BLE gateway script to the BTHome object names.
+var decodedNames = map[string]string{
+	"pid":         "packet_id",
+	"Battery":     "battery",
+	"Temperature": "temperature",
+	"Humidity":    "humidity",
+	"Illuminance": "illuminance",
+	"Motion":      "motion",
+	"Window":      "window",
+	"Button":      "button",
+	"Rotation":    "rotation",
+}
+
+// Advertisement is a BLU advertisement relayed by a Gen2/Gen3 gateway.
+type Advertisement struct {
+	Address string
+	RSSI    float64
+	HasRSSI bool
+	Packet  Packet
+}
+
+// FromEventData extracts the advertisement from the data of a NotifyEvent
+// entry. The gateway script either sends the values already decoded
+//
+//	{"address":"0b:ae:5f:33:9b:3c","rssi":-67,"pid":118,"Battery":100,"Temperature":21.4}
+//
+// or the raw service data as hex or base64
+//
+//	{"addr":"0b:ae:5f:33:9b:3c","rssi":-67,"service_data":{"fcd2":"4400a101642e3345e300"}}
+//
+// ok is false if data isn't a BLU advertisement at all.
+func FromEventData(data gjson.Result) (adv Advertisement, ok bool, err error) {
+	adv.Address = strings.ToLower(data.Get("address").String())
+	if adv.Address == "" {
+		adv.Address = strings.ToLower(data.Get("addr").String())
+	}
+	if adv.Address == "" {
+		return adv, false, nil
+	}
+	if v := data.Get("rssi"); v.Exists() {
+		adv.RSSI, adv.HasRSSI = v.Float(), true
+	}
+
+	if raw := data.Get("service_data.fcd2"); raw.Exists() {
+		b, err := decodeServiceData(raw.String())
+		if err != nil {
+			return adv, true, err
+		}
+		adv.Packet, err = Decode(b)
+		return adv, true, err
+	}
+
+	adv.Packet = Packet{Version: int(data.Get("BTHome_version").Int()), Encrypted: data.Get("encryption").Bool()}
+	data.ForEach(func(key, value gjson.Result) bool {
+		name, ok := decodedNames[key.String()]
+		if !ok {
+			return true
+		}
+		if value.IsArray() {
+			for _, v := range value.Array() {
+				adv.Packet.Objects = append(adv.Packet.Objects, Object{Name: name, Value: v.Float()})
+			}
+			return true
+		}
+		adv.Packet.Objects = append(adv.Packet.Objects, Object{Name: name, Value: value.Float()})
+		return true
+	})
+	if len(adv.Packet.Objects) == 0 {
+		return adv, false, nil
+	}
+	return adv, true, nil
+}
+
+func decodeServiceData(s string) ([]byte, error) {
+	if b, err := hex.DecodeString(s); err == nil {
+		return b, nil
+	}
+	b, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("bthome: service data %q is neither hex nor base64", s)
+	}
+	return b, nil
+}
@@ -0,0 +1,153 @@
+package bthome
+
+import (
+	"context"
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"github.com/SchumacherFM/prometheus_shelly_exporter/collector"
+	"github.com/SchumacherFM/prometheus_shelly_exporter/collector/collectortest"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+	"github.com/tidwall/gjson"
+	"go.uber.org/zap"
+)
+
+var _ prometheus.Collector = (*Collector)(nil)
+
+func mustHex(t *testing.T, s string) []byte {
+	b, err := hex.DecodeString(s)
+	require.NoError(t, err)
+	return b
+}
+
+func TestDecode(t *testing.T) {
+	t.Run("BLU H&T", func(t *testing.T) {
+		// packet ID 161, battery 100%, humidity 51%, temperature 22.7°C
+		p, err := Decode(mustHex(t, "4400a101642e3345e300"))
+		require.NoError(t, err)
+		require.Equal(t, 2, p.Version)
+		require.True(t, p.TriggerBased)
+		require.Equal(t, []Object{
+			{ID: 0x00, Name: "packet_id", Value: 161},
+			{ID: 0x01, Name: "battery", Value: 100},
+			{ID: 0x2E, Name: "humidity", Value: 51},
+			{ID: 0x45, Name: "temperature", Value: 22.7},
+		}, p.Objects)
+	})
+	t.Run("negative temperature", func(t *testing.T) {
+		p, err := Decode(mustHex(t, "4002caf6"))
+		require.NoError(t, err)
+		v, ok := p.Value("temperature")
+		require.True(t, ok)
+		require.InDelta(t, -23.58, v, 0.0001)
+	})
+	t.Run("BLU Door/Window with illuminance", func(t *testing.T) {
+		p, err := Decode(mustHex(t, "44001501630550c3002d013f0000"))
+		require.NoError(t, err)
+		lux, _ := p.Value("illuminance")
+		require.InDelta(t, 500, lux, 0.0001)
+		window, _ := p.Value("window")
+		require.Equal(t, 1.0, window)
+		rotation, _ := p.Value("rotation")
+		require.Equal(t, 0.0, rotation)
+	})
+	t.Run("BLU RC Button 4", func(t *testing.T) {
+		p, err := Decode(mustHex(t, "4400200164"+"3a01"+"3a00"+"3a04"+"3a00"))
+		require.NoError(t, err)
+		require.Equal(t, []float64{1, 0, 4, 0}, p.Values("button"))
+		require.Equal(t, "long_press", ButtonEvent(4))
+	})
+	t.Run("skips text", func(t *testing.T) {
+		p, err := Decode(mustHex(t, "4053034142430164"))
+		require.NoError(t, err)
+		require.Equal(t, []Object{{ID: 0x01, Name: "battery", Value: 100}}, p.Objects)
+	})
+	t.Run("encrypted", func(t *testing.T) {
+		_, err := Decode(mustHex(t, "41a47c3e7e0f"))
+		require.ErrorIs(t, err, ErrEncrypted)
+	})
+	t.Run("version 1", func(t *testing.T) {
+		_, err := Decode(mustHex(t, "2002caf6"))
+		require.ErrorIs(t, err, ErrVersion)
+	})
+	t.Run("truncated", func(t *testing.T) {
+		_, err := Decode(mustHex(t, "4045e3"))
+		require.ErrorIs(t, err, ErrShortPacket)
+	})
+	t.Run("unknown object", func(t *testing.T) {
+		_, err := Decode(mustHex(t, "40fe00"))
+		require.ErrorContains(t, err, "unknown object ID 0xFE")
+	})
+}
+
+func TestFromEventData(t *testing.T) {
+	t.Run("decoded by the gateway script", func(t *testing.T) {
+		adv, ok, err := FromEventData(gjson.Parse(`{"encryption":false,"BTHome_version":2,"pid":118,"Battery":100,"Temperature":21.4,"Humidity":48,"Button":[1,0,0,0],"rssi":-67,"address":"0B:AE:5F:33:9B:3C"}`))
+		require.NoError(t, err)
+		require.True(t, ok)
+		require.Equal(t, "0b:ae:5f:33:9b:3c", adv.Address)
+		require.True(t, adv.HasRSSI)
+		require.Equal(t, -67.0, adv.RSSI)
+		tmp, _ := adv.Packet.Value("temperature")
+		require.Equal(t, 21.4, tmp)
+		require.Equal(t, []float64{1, 0, 0, 0}, adv.Packet.Values("button"))
+	})
+	t.Run("raw hex", func(t *testing.T) {
+		adv, ok, err := FromEventData(gjson.Parse(`{"addr":"0b:ae:5f:33:9b:3c","rssi":-70,"service_data":{"fcd2":"4400a101642e3345e300"}}`))
+		require.NoError(t, err)
+		require.True(t, ok)
+		hum, _ := adv.Packet.Value("humidity")
+		require.Equal(t, 51.0, hum)
+	})
+	t.Run("raw base64", func(t *testing.T) {
+		adv, ok, err := FromEventData(gjson.Parse(`{"addr":"0b:ae:5f:33:9b:3c","service_data":{"fcd2":"RAChAWQuM0XjAA=="}}`))
+		require.NoError(t, err)
+		require.True(t, ok)
+		require.False(t, adv.HasRSSI)
+		bat, _ := adv.Packet.Value("battery")
+		require.Equal(t, 100.0, bat)
+	})
+	t.Run("not an advertisement", func(t *testing.T) {
+		_, ok, err := FromEventData(gjson.Parse(`{"state":true}`))
+		require.NoError(t, err)
+		require.False(t, ok)
+	})
+}
+
+func TestCollector_skipsMotion(t *testing.T) {
+	msgChan := make(chan mqtt.Message)
+	msgGoRoutineDone := make(chan struct{})
+	c := NewCollector(context.Background(), msgChan, Options{
+		Options: collector.Options{Log: zap.NewNop()},
+		TestCB: func() {
+			close(msgGoRoutineDone)
+		},
+	})
+	collectortest.Send(msgChan,
+		`shellyplus1-a8032ab12345/events/rpc {"src":"shellyplus1-a8032ab12345","method":"NotifyEvent","params":{"ts":1707640900.12,"events":[{"component":"script:1","event":"shelly-blu","data":{"BTHome_version":2,"pid":118,"Battery":100,"Illuminance":120,"Motion":1,"address":"0b:ae:5f:33:9b:3c"}}]}}`,
+		`shellyplus1-a8032ab12345/events/rpc {"src":"shellyplus1-a8032ab12345","method":"NotifyEvent","params":{"ts":1707640900.52,"events":[{"component":"script:1","event":"shelly-blu","data":{"BTHome_version":2,"pid":12,"Battery":90,"Illuminance":80,"Window":1,"address":"3c:2e:f5:71:d5:2a"}}]}}`,
+	)
+	close(msgChan)
+	<-msgGoRoutineDone
+
+	err := testutil.CollectAndCompare(c, strings.NewReader(`
+# HELP shellyblu_battery Sensor battery
+# TYPE shellyblu_battery gauge
+shellyblu_battery{device="3c:2e:f5:71:d5:2a",unit="%"} 90
+# HELP shellyblu_illuminance illuminance in lux
+# TYPE shellyblu_illuminance gauge
+shellyblu_illuminance{device="3c:2e:f5:71:d5:2a"} 80
+# HELP shellyblu_up 1 if the last reading of the device was fine
+# TYPE shellyblu_up gauge
+shellyblu_up{device="3c:2e:f5:71:d5:2a"} 1
+`),
+		"shellyblu_battery",
+		"shellyblu_illuminance",
+		"shellyblu_up",
+	)
+	require.NoError(t, err)
+}
@@ -0,0 +1,283 @@
+package bthome
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/SchumacherFM/prometheus_shelly_exporter/collector"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/tidwall/gjson"
+	"go.uber.org/zap"
+)
+
+// The collector exports Shelly BLU sensors (H&T, Door/Window, Button) relayed
+// by a Gen2/Gen3 device on <prefix>/events/rpc. Advertisements arrive as
+// NotifyEvent from the BLE gateway script and are labelled with the BLU MAC,
+// BLU Motion is left to the motion collector. Devices paired natively report
+// the components bthomedevice:<N> and bthomesensor:<N> in their status which
+// only know the component ID.
+
+// gauges lists the BTHome objects exported as gauge.
+var gauges = []string{"temperature", "humidity", "battery", "illuminance", "window"}
+
+type device struct {
+	values       map[string]float64 // object name => value
+	rssi         float64
+	hasRSSI      bool
+	lastPacketID float64
+	buttons      map[string]map[string]float64 // button index => event => count
+}
+
+type Collector struct {
+	opts           Options
+	tmpDesc        *prometheus.Desc
+	humDesc        *prometheus.Desc
+	batDesc        *prometheus.Desc
+	luxDesc        *prometheus.Desc
+	windowDesc     *prometheus.Desc
+	rssiDesc       *prometheus.Desc
+	buttonDesc     *prometheus.Desc
+	componentDesc  *prometheus.Desc
+	health         *collector.Health
+	objectToDescs  map[string]*prometheus.Desc
+	objectToLabels map[string][]string
+
+	mu         sync.Mutex
+	devices    map[string]*device                       // BLU MAC => state
+	components map[string]map[string]map[string]float64 // gateway => component key => field => value
+}
+
+type Options struct {
+	collector.Options
+	TestCB func()
+}
+
+func init() {
+	collector.Register(collector.Definition{
+		Name:          "bthome",
+		Help:          "Shelly BLU devices relayed by a Gen2/Gen3 gateway",
+		Default:       true,
+		Subscriptions: []string{"+/events/rpc"},
+		New: func(ctx context.Context, messageChan <-chan mqtt.Message, opts collector.Options) prometheus.Collector {
+			return NewCollector(ctx, messageChan, Options{Options: opts})
+		},
+	})
+}
+
+func NewCollector(ctx context.Context, messageChan <-chan mqtt.Message, opts Options) *Collector {
+	c := &Collector{
+		opts:          opts,
+		tmpDesc:       prometheus.NewDesc("shellyblu_temperature", "Sensor temperature", []string{"device", "unit"}, nil),
+		humDesc:       prometheus.NewDesc("shellyblu_humidity", "Sensor humidity", []string{"device", "unit"}, nil),
+		batDesc:       prometheus.NewDesc("shellyblu_battery", "Sensor battery", []string{"device", "unit"}, nil),
+		luxDesc:       prometheus.NewDesc("shellyblu_illuminance", "illuminance in lux", []string{"device"}, nil),
+		windowDesc:    prometheus.NewDesc("shellyblu_window", "1 if the window or door is open", []string{"device"}, nil),
+		rssiDesc:      prometheus.NewDesc("shellyblu_rssi", "signal strength of the last advertisement in dBm", []string{"device"}, nil),
+		buttonDesc:    prometheus.NewDesc("shellyblu_button_events_total", "button events since the exporter started", []string{"device", "button", "event"}, nil),
+		componentDesc: prometheus.NewDesc("shellyblu_component_value", "value of a bthomedevice or bthomesensor component of the gateway", []string{"gateway", "component", "field"}, nil),
+		health:        collector.NewHealth("shellyblu", opts.Options),
+		devices:       make(map[string]*device),
+		components:    make(map[string]map[string]map[string]float64),
+	}
+	c.objectToDescs = map[string]*prometheus.Desc{
+		"temperature": c.tmpDesc,
+		"humidity":    c.humDesc,
+		"battery":     c.batDesc,
+		"illuminance": c.luxDesc,
+		"window":      c.windowDesc,
+	}
+	c.objectToLabels = map[string][]string{
+		"temperature": {"c"},
+		"humidity":    {"%"},
+		"battery":     {"%"},
+	}
+
+	go func() {
+		for {
+			select {
+			case msg, ok := <-messageChan:
+				if !ok {
+					if opts.TestCB != nil {
+						opts.TestCB()
+					}
+					return
+				}
+				if false == strings.HasSuffix(msg.Topic(), "/rpc") {
+					continue
+				}
+
+				c.handle(msg.Topic(), msg.Payload())
+
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return c
+}
+
+func (c *Collector) handle(topic string, payload []byte) {
+	r := gjson.ParseBytes(payload)
+	switch r.Get("method").String() {
+	case "NotifyEvent":
+		c.handleEvents(topic, r.Get("params.events"), r.Get("params.ts").Float())
+	case "NotifyStatus", "NotifyFullStatus":
+		c.handleComponents(r.Get("src").String(), r.Get("params"))
+	}
+}
+
+func (c *Collector) handleEvents(topic string, events gjson.Result, ts float64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	events.ForEach(func(_, event gjson.Result) bool {
+		adv, ok, err := FromEventData(event.Get("data"))
+		switch {
+		case !ok:
+			return true
+		case err != nil:
+			c.health.ParseError(adv.Address, topic, fmt.Errorf("advertisement of %s: %w", adv.Address, err))
+			return true
+		}
+		if _, ok := adv.Packet.Value("motion"); ok {
+			return true // BLU Motion belongs to the motion collector
+		}
+		c.opts.Log.Debug("BLU advertisement",
+			zap.String("topic", topic),
+			zap.String("address", adv.Address),
+			zap.Int("objects", len(adv.Packet.Objects)))
+
+		c.opts.Timestamps.Observe(adv.Address, ts)
+		c.health.OK(adv.Address)
+		d, ok := c.devices[adv.Address]
+		if !ok {
+			d = &device{
+				values:       make(map[string]float64),
+				lastPacketID: -1,
+				buttons:      make(map[string]map[string]float64),
+			}
+			c.devices[adv.Address] = d
+		}
+		if adv.HasRSSI {
+			d.rssi, d.hasRSSI = adv.RSSI, true
+		}
+		for _, name := range gauges {
+			if v, ok := adv.Packet.Value(name); ok {
+				d.values[name] = v
+			}
+		}
+
+		// The same advertisement gets relayed several times, only a new
+		// packet ID is a new button event.
+		pid, hasPID := adv.Packet.Value("packet_id")
+		if hasPID && pid == d.lastPacketID {
+			return true
+		}
+		d.lastPacketID = pid
+		for i, v := range adv.Packet.Values("button") {
+			if v == 0 {
+				continue // no event on this button
+			}
+			button := strconv.Itoa(i)
+			if d.buttons[button] == nil {
+				d.buttons[button] = make(map[string]float64)
+			}
+			d.buttons[button][ButtonEvent(v)]++
+		}
+		return true
+	})
+}
+
+func (c *Collector) handleComponents(gateway string, params gjson.Result) {
+	if gateway == "" {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	params.ForEach(func(key, value gjson.Result) bool {
+		k := key.String()
+		if !strings.HasPrefix(k, "bthomedevice:") && !strings.HasPrefix(k, "bthomesensor:") {
+			return true
+		}
+		c.opts.Timestamps.Observe(gateway, params.Get("ts").Float())
+		c.health.OK(gateway)
+		comps, ok := c.components[gateway]
+		if !ok {
+			comps = make(map[string]map[string]float64)
+			c.components[gateway] = comps
+		}
+		fields, ok := comps[k]
+		if !ok {
+			fields = make(map[string]float64)
+			comps[k] = fields
+		}
+		for _, field := range []string{"value", "battery", "rssi", "packet_id"} {
+			v := value.Get(field)
+			switch {
+			case !v.Exists() || v.Type == gjson.Null:
+			case v.IsBool():
+				fields[field] = 0
+				if v.Bool() {
+					fields[field] = 1
+				}
+			default:
+				fields[field] = v.Float()
+			}
+		}
+		return true
+	})
+}
+
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.tmpDesc
+	ch <- c.humDesc
+	ch <- c.batDesc
+	ch <- c.luxDesc
+	ch <- c.windowDesc
+	ch <- c.rssiDesc
+	ch <- c.buttonDesc
+	ch <- c.componentDesc
+	c.health.Describe(ch)
+}
+
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	c.collect(ch)
+	c.health.Collect(ch)
+}
+
+func (c *Collector) collect(ch chan<- prometheus.Metric) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for addr, d := range c.devices {
+		for name, v := range d.values {
+			labels := append([]string{addr}, c.objectToLabels[name]...)
+			ch <- prometheus.MustNewConstMetric(c.objectToDescs[name], prometheus.GaugeValue, v, labels...)
+		}
+		if d.hasRSSI {
+			ch <- prometheus.MustNewConstMetric(c.rssiDesc, prometheus.GaugeValue, d.rssi, addr)
+		}
+		for button, events := range d.buttons {
+			for event, count := range events {
+				ch <- prometheus.MustNewConstMetric(c.buttonDesc, prometheus.CounterValue, count, addr, button, event)
+			}
+		}
+	}
+	for gateway, comps := range c.components {
+		if c.opts.Presence.Offline(gateway) {
+			continue
+		}
+		for comp, fields := range comps {
+			for field, v := range fields {
+				ch <- prometheus.MustNewConstMetric(c.componentDesc, prometheus.GaugeValue, v, gateway, comp, field)
+			}
+		}
+	}
+}
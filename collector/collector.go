@@ -0,0 +1,184 @@
+package collector
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+// Options are shared by all device collectors. Device packages embed them in
+// their own Options.
+type Options struct {
+	// Name of the collector in the exporter's own metrics.
+	Name string
+	// StaleAfter marks a device as down if it sent no reading for this long,
+	// 0 disables it.
+	StaleAfter time.Duration
+	Log        *zap.Logger
+	// Publish sends a message to the broker, e.g. an RPC request to a device.
+	// It is nil unless requests are enabled for the collector.
+	Publish func(topic string, payload []byte)
+	// RequestInterval is the minimum time between two requests to the same
+	// device.
+	RequestInterval time.Duration
+	// Presence knows the devices which are offline, it may be nil.
+	Presence *Presence
+	// Timestamps receives the time of each reading per device label if device
+	// timestamps are enabled, otherwise it is nil.
+	Timestamps *Timestamps
+	// Stats counts parse errors, it may be nil.
+	Stats *Stats
+}
+
+// Factory creates the collector of a device package. It reads the messages of
+// messageChan until the channel gets closed or ctx is done.
+type Factory func(ctx context.Context, messageChan <-chan mqtt.Message, opts Options) prometheus.Collector
+
+// Definition describes a device collector. Device packages register it in
+// their init function.
+type Definition struct {
+	// Name is used for the --collector.<name> flag.
+	Name string
+	// Help describes the supported devices.
+	Help string
+	// Default enables the collector if the flag is not set.
+	Default bool
+	// Subscriptions are the MQTT topic filters the collector needs.
+	Subscriptions []string
+	// Handles reports whether messages of the topic are of interest. If nil,
+	// the topic must match one of the Subscriptions.
+	Handles func(topic string) bool
+	// Requests reports whether the collector can ask devices for data with
+	// Options.Publish, the user has to enable it.
+	Requests bool
+	New      Factory
+}
+
+// HandlesTopic reports whether the collector is interested in the topic.
+func (d Definition) HandlesTopic(topic string) bool {
+	if d.Handles != nil {
+		return d.Handles(topic)
+	}
+	for _, filter := range d.Subscriptions {
+		if TopicMatches(filter, topic) {
+			return true
+		}
+	}
+	return false
+}
+
+var (
+	definitionsMu sync.Mutex
+	definitions   = map[string]Definition{}
+)
+
+// Register adds a device collector. It panics if the name is already taken as
+// this is a programming error.
+func Register(d Definition) {
+	definitionsMu.Lock()
+	defer definitionsMu.Unlock()
+
+	if _, ok := definitions[d.Name]; ok {
+		panic(fmt.Sprintf("collector: %q registered twice", d.Name))
+	}
+	definitions[d.Name] = d
+}
+
+// Definitions returns all registered collectors sorted by name.
+func Definitions() []Definition {
+	definitionsMu.Lock()
+	defer definitionsMu.Unlock()
+
+	defs := make([]Definition, 0, len(definitions))
+	for _, d := range definitions {
+		defs = append(defs, d)
+	}
+	sort.Slice(defs, func(i, j int) bool {
+		return defs[i].Name < defs[j].Name
+	})
+	return defs
+}
+
+// TopicMatches reports whether the topic matches the MQTT topic filter with
+// the wildcards + (one level) and # (all remaining levels).
+func TopicMatches(filter, topic string) bool {
+	filterLevels := strings.Split(filter, "/")
+	topicLevels := strings.Split(topic, "/")
+	for i, fl := range filterLevels {
+		switch {
+		case fl == "#":
+			return true
+		case i >= len(topicLevels):
+			return false
+		case fl == "+":
+		case fl != topicLevels[i]:
+			return false
+		}
+	}
+	return len(filterLevels) == len(topicLevels)
+}
+
+// FilterCovers reports whether every topic matching other also matches
+// filter, e.g. shellies/# covers shellies/+/info.
+func FilterCovers(filter, other string) bool {
+	filterLevels := strings.Split(filter, "/")
+	otherLevels := strings.Split(other, "/")
+	for i, fl := range filterLevels {
+		switch {
+		case fl == "#":
+			return true
+		case i >= len(otherLevels), otherLevels[i] == "#":
+			return false
+		case fl == "+":
+		case fl != otherLevels[i]:
+			return false
+		}
+	}
+	return len(filterLevels) == len(otherLevels)
+}
+
+// MinimalFilters returns the sorted topic filters without duplicates and
+// without the ones covered by another filter.
+func MinimalFilters(filters []string) []string {
+	var minimal []string
+	for i, filter := range filters {
+		covered := false
+		for j, other := range filters {
+			// of two equal filters the first one stays
+			if i != j && FilterCovers(other, filter) && (other != filter || j < i) {
+				covered = true
+				break
+			}
+		}
+		if !covered {
+			minimal = append(minimal, filter)
+		}
+	}
+	sort.Strings(minimal)
+	return minimal
+}
+
+// ValidTopicFilter reports whether filter is a valid MQTT topic filter: not
+// empty, + only as a whole level and # only as the last level.
+func ValidTopicFilter(filter string) bool {
+	if filter == "" {
+		return false
+	}
+	levels := strings.Split(filter, "/")
+	for i, l := range levels {
+		switch {
+		case l == "#" && i != len(levels)-1:
+			return false
+		case l != "#" && l != "+" && strings.ContainsAny(l, "#+"):
+			return false
+		}
+	}
+	return true
+}
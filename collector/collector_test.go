@@ -0,0 +1,70 @@
+package collector
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTopicMatches(t *testing.T) {
+	tests := []struct {
+		filter, topic string
+		want          bool
+	}{
+		{"shellies/+/info", "shellies/shellyht-1/info", true},
+		{"shellies/+/info", "shellies/shellyht-1/online", false},
+		{"shellies/+/info", "shellies/info", false},
+		{"shellies/+/emeter/#", "shellies/3em/emeter/0/power", true},
+		{"shellies/+/emeter/#", "shellies/3em/relay/0", false},
+		{"+/events/rpc", "shellyplus1-abc/events/rpc", true},
+		{"+/events/rpc", "a/b/events/rpc", false},
+		{"#", "any/topic", true},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, TopicMatches(tt.filter, tt.topic), "%s %s", tt.filter, tt.topic)
+	}
+}
+
+func TestValidTopicFilter(t *testing.T) {
+	for filter, want := range map[string]bool{
+		"shellies/+/info":     true,
+		"shellies/#":          true,
+		"#":                   true,
+		"":                    false,
+		"shellies/#/info":     false,
+		"shellies/shelly+/on": false,
+		"shellies/a#":         false,
+	} {
+		assert.Equal(t, want, ValidTopicFilter(filter), filter)
+	}
+}
+
+func TestFilterCovers(t *testing.T) {
+	tests := []struct {
+		filter, other string
+		want          bool
+	}{
+		{"shellies/#", "shellies/+/info", true},
+		{"shellies/#", "shellies", true},
+		{"shellies/+/info", "shellies/shellyht-1/info", true},
+		{"shellies/+/info", "shellies/+/info", true},
+		{"shellies/shellyht-1/info", "shellies/+/info", false},
+		{"shellies/+/info", "shellies/#", false},
+		{"+/events/rpc", "shellies/+/info", false},
+		{"shellies/+", "shellies/+/info", false},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.want, FilterCovers(test.filter, test.other), "%s covers %s", test.filter, test.other)
+	}
+}
+
+func TestMinimalFilters(t *testing.T) {
+	got := MinimalFilters([]string{
+		"shellies/+/info", "+/events/rpc", "shellies/+/sensor/#", "shellies/+/info",
+		"shellies/+/sensor/flood", "+/events/rpc", "shellies/announce",
+	})
+	assert.Equal(t, []string{"+/events/rpc", "shellies/+/info", "shellies/+/sensor/#", "shellies/announce"}, got)
+
+	assert.Equal(t, []string{"#"}, MinimalFilters([]string{"shellies/#", "#", "+/online"}))
+	assert.Empty(t, MinimalFilters(nil))
+}
@@ -0,0 +1,74 @@
+// Package collectortest feeds MQTT messages to the device collectors in tests.
+package collectortest
+
+import (
+	"bufio"
+	"os"
+	"strings"
+	"testing"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// Message is a received mqtt.Message with QoS 0.
+type Message struct {
+	topic    string
+	payload  []byte
+	retained bool
+}
+
+var _ mqtt.Message = Message{}
+
+// NewMessage returns a message of the topic.
+func NewMessage(topic, payload string) Message {
+	return Message{topic: topic, payload: []byte(payload)}
+}
+
+// NewRetained returns a message of the topic the broker kept and delivers to
+// each new subscriber.
+func NewRetained(topic, payload string) Message {
+	m := NewMessage(topic, payload)
+	m.retained = true
+	return m
+}
+
+func (Message) Duplicate() bool { return false }
+
+func (Message) Qos() byte { return 0 }
+
+func (m Message) Retained() bool { return m.retained }
+
+func (m Message) Topic() string { return m.topic }
+
+func (Message) MessageID() uint16 { return 0 }
+
+func (m Message) Payload() []byte { return m.payload }
+
+func (Message) Ack() {}
+
+// Send sends one message per line, the topic and the payload are separated by
+// the first space.
+func Send(ch chan<- mqtt.Message, lines ...string) {
+	for _, line := range lines {
+		topic, payload, _ := strings.Cut(line, " ")
+		ch <- NewMessage(topic, payload)
+	}
+}
+
+// SendFile sends the lines of the file, see Send.
+func SendFile(t testing.TB, ch chan<- mqtt.Message, path string) {
+	t.Helper()
+	fp, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fp.Close()
+
+	s := bufio.NewScanner(fp)
+	for s.Scan() {
+		Send(ch, s.Text())
+	}
+	if err := s.Err(); err != nil {
+		t.Fatal(err)
+	}
+}
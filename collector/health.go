@@ -0,0 +1,155 @@
+package collector
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Reason is the fixed set of causes in <prefix>_scrape_errors_total. Details
+// like the payload only go to the log to keep the cardinality bounded.
+type Reason string
+
+const (
+	// ReasonParseError is a payload which could not be parsed.
+	ReasonParseError Reason = "parse_error"
+	// ReasonInvalidReading is a reading the device marked as invalid, e.g. a
+	// disconnected sensor.
+	ReasonInvalidReading Reason = "invalid_reading"
+	// ReasonStale is a device without a reading within Options.StaleAfter.
+	ReasonStale Reason = "stale"
+)
+
+var reasons = []Reason{ReasonParseError, ReasonInvalidReading, ReasonStale}
+
+type deviceHealth struct {
+	id     string // device ID of the presence if it differs from the label
+	ok     bool
+	stale  bool
+	last   time.Time
+	errors map[Reason]float64
+}
+
+// healthMetric marks the metrics of Health, WithTimestamps leaves them at the
+// scrape time.
+type healthMetric struct {
+	prometheus.Metric
+}
+
+// Health exports <prefix>_up{device} and
+// <prefix>_scrape_errors_total{device,reason} of a collector. A device is up
+// if its last reading was fine, not stale and the device is not offline. The
+// series of an offline device stay with up 0 while the collectors drop the
+// others.
+type Health struct {
+	opts       Options
+	upDesc     *prometheus.Desc
+	errorsDesc *prometheus.Desc
+	now        func() time.Time
+
+	mu      sync.Mutex
+	devices map[string]*deviceHealth
+}
+
+func NewHealth(prefix string, opts Options) *Health {
+	return &Health{
+		opts:       opts,
+		upDesc:     prometheus.NewDesc(prefix+"_up", "1 if the last reading of the device was fine", []string{"device"}, nil),
+		errorsDesc: prometheus.NewDesc(prefix+"_scrape_errors_total", "readings of the device which failed", []string{"device", "reason"}, nil),
+		now:        time.Now,
+		devices:    make(map[string]*deviceHealth),
+	}
+}
+
+// device returns the health of the device and creates it if needed. The
+// caller must hold h.mu.
+func (h *Health) device(device string) *deviceHealth {
+	d, ok := h.devices[device]
+	if !ok {
+		d = &deviceHealth{errors: make(map[Reason]float64)}
+		h.devices[device] = d
+	}
+	return d
+}
+
+// OK records a valid reading of the device.
+func (h *Health) OK(device string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	d := h.device(device)
+	d.ok, d.stale, d.last = true, false, h.now()
+}
+
+// SetID sets the device ID the presence knows for a device label which is
+// not the ID, like the MAC of the H&T.
+func (h *Health) SetID(device, id string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.device(device).id = id
+}
+
+// Error counts a failed reading, the device is down until the next OK.
+func (h *Health) Error(device string, reason Reason) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	d := h.device(device)
+	d.ok = false
+	d.errors[reason]++
+}
+
+// ParseError logs and counts a payload which could not be parsed. Without a
+// device in the payload the device comes from the topic, see DeviceFromTopic.
+func (h *Health) ParseError(device, topic string, err error) {
+	h.opts.ParseError(topic, err)
+	if device == "" {
+		device = DeviceFromTopic(topic)
+	}
+	h.Error(device, ReasonParseError)
+}
+
+// DeviceFromTopic returns <id> of shellies/<id>/... and <prefix> of the Gen2
+// topics <prefix>/....
+func DeviceFromTopic(topic string) string {
+	levels := strings.Split(topic, "/")
+	if levels[0] == "shellies" && len(levels) > 2 {
+		return levels[1]
+	}
+	return levels[0]
+}
+
+func (h *Health) Describe(ch chan<- *prometheus.Desc) {
+	ch <- h.upDesc
+	ch <- h.errorsDesc
+}
+
+func (h *Health) Collect(ch chan<- prometheus.Metric) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	now := h.now()
+	for device, d := range h.devices {
+		id := d.id
+		if id == "" {
+			id = device
+		}
+		offline := h.opts.Presence.Offline(id)
+		// an offline device is expected to be silent
+		if h.opts.StaleAfter > 0 && !offline && d.ok && !d.stale && now.Sub(d.last) > h.opts.StaleAfter {
+			d.stale = true
+			d.errors[ReasonStale]++
+		}
+		var up float64
+		if d.ok && !d.stale && !offline {
+			up = 1
+		}
+		ch <- healthMetric{prometheus.MustNewConstMetric(h.upDesc, prometheus.GaugeValue, up, device)}
+		for _, reason := range reasons {
+			ch <- healthMetric{prometheus.MustNewConstMetric(h.errorsDesc, prometheus.CounterValue, d.errors[reason], device, string(reason))}
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package collector
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestHealth(t *testing.T) {
+	now := time.Date(2024, 2, 11, 9, 0, 0, 0, time.UTC)
+	presence := NewPresence()
+	h := NewHealth("shellyht", Options{
+		Name:       "ht",
+		Log:        zap.NewNop(),
+		Presence:   presence,
+		StaleAfter: time.Hour,
+	})
+	h.now = func() time.Time { return now }
+
+	h.OK("shellyht-1")
+	h.OK("shellyht-2")
+	h.ParseError("", "shellies/shellyht-2/info", errors.New("invalid JSON"))
+	h.Error("shellyht-3", ReasonInvalidReading)
+	h.OK("C45BBE6FDA5D")
+	h.SetID("C45BBE6FDA5D", "shellyht-6FDA5D")
+	presence.Set("shellyht-6FDA5D", false) // up 0, silent but not stale
+
+	now = now.Add(30 * time.Minute)
+	h.OK("shellyht-1")
+	now = now.Add(31 * time.Minute)
+
+	err := testutil.CollectAndCompare(h, strings.NewReader(`
+# HELP shellyht_scrape_errors_total readings of the device which failed
+# TYPE shellyht_scrape_errors_total counter
+shellyht_scrape_errors_total{device="C45BBE6FDA5D",reason="invalid_reading"} 0
+shellyht_scrape_errors_total{device="C45BBE6FDA5D",reason="parse_error"} 0
+shellyht_scrape_errors_total{device="C45BBE6FDA5D",reason="stale"} 0
+shellyht_scrape_errors_total{device="shellyht-1",reason="invalid_reading"} 0
+shellyht_scrape_errors_total{device="shellyht-1",reason="parse_error"} 0
+shellyht_scrape_errors_total{device="shellyht-1",reason="stale"} 0
+shellyht_scrape_errors_total{device="shellyht-2",reason="invalid_reading"} 0
+shellyht_scrape_errors_total{device="shellyht-2",reason="parse_error"} 1
+shellyht_scrape_errors_total{device="shellyht-2",reason="stale"} 0
+shellyht_scrape_errors_total{device="shellyht-3",reason="invalid_reading"} 1
+shellyht_scrape_errors_total{device="shellyht-3",reason="parse_error"} 0
+shellyht_scrape_errors_total{device="shellyht-3",reason="stale"} 0
+# HELP shellyht_up 1 if the last reading of the device was fine
+# TYPE shellyht_up gauge
+shellyht_up{device="C45BBE6FDA5D"} 0
+shellyht_up{device="shellyht-1"} 1
+shellyht_up{device="shellyht-2"} 0
+shellyht_up{device="shellyht-3"} 0
+`))
+	require.NoError(t, err)
+
+	h.OK("shellyht-2")
+	now = now.Add(2 * time.Hour)
+	h.OK("shellyht-1")
+	// collecting twice counts a stale device once
+	testutil.CollectAndCount(h)
+	err = testutil.CollectAndCompare(h, strings.NewReader(`
+# HELP shellyht_up 1 if the last reading of the device was fine
+# TYPE shellyht_up gauge
+shellyht_up{device="C45BBE6FDA5D"} 0
+shellyht_up{device="shellyht-1"} 1
+shellyht_up{device="shellyht-2"} 0
+shellyht_up{device="shellyht-3"} 0
+`), "shellyht_up")
+	require.NoError(t, err)
+	assert.Equal(t, 1.0, h.devices["shellyht-2"].errors[ReasonStale])
+}
+
+func TestDeviceFromTopic(t *testing.T) {
+	assert.Equal(t, "shellyht-6FDA5D", DeviceFromTopic("shellies/shellyht-6FDA5D/info"))
+	assert.Equal(t, "shellyplus1pm-a8032ab12345", DeviceFromTopic("shellyplus1pm-a8032ab12345/events/rpc"))
+}
@@ -0,0 +1,57 @@
+package collector
+
+import "sync"
+
+// Presence tracks the online state of devices from their last will topics,
+// shellies/<id>/online for Gen1 and <prefix>/online for Gen2. Collectors skip
+// the series of offline devices, so they go stale instead of repeating the
+// last reading.
+type Presence struct {
+	mu          sync.RWMutex
+	online      map[string]bool    // device ID => online
+	transitions map[string]float64 // device ID => online/offline changes
+}
+
+func NewPresence() *Presence {
+	return &Presence{
+		online:      make(map[string]bool),
+		transitions: make(map[string]float64),
+	}
+}
+
+// Set stores the state of the device. A change of a known state counts as
+// transition.
+func (p *Presence) Set(device string, online bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if prev, ok := p.online[device]; ok && prev != online {
+		p.transitions[device]++
+	} else if !ok {
+		p.transitions[device] = 0
+	}
+	p.online[device] = online
+}
+
+// Offline reports whether the device announced it is offline. Devices without
+// a last will are never offline. It is safe to call on a nil Presence.
+func (p *Presence) Offline(device string) bool {
+	if p == nil {
+		return false
+	}
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	online, ok := p.online[device]
+	return ok && !online
+}
+
+// Each calls fn for every known device, it must not call back into p.
+func (p *Presence) Each(fn func(device string, online bool, transitions float64)) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	for device, online := range p.online {
+		fn(device, online, p.transitions[device])
+	}
+}
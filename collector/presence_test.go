@@ -0,0 +1,27 @@
+package collector
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPresence(t *testing.T) {
+	var nilPresence *Presence
+	assert.False(t, nilPresence.Offline("shellyht-1"))
+
+	p := NewPresence()
+	assert.False(t, p.Offline("shellyht-1"), "unknown devices are not offline")
+
+	p.Set("shellyht-1", false)
+	assert.True(t, p.Offline("shellyht-1"))
+	p.Set("shellyht-1", true)
+	p.Set("shellyht-1", true)
+	assert.False(t, p.Offline("shellyht-1"))
+
+	p.Each(func(device string, online bool, transitions float64) {
+		assert.Equal(t, "shellyht-1", device)
+		assert.True(t, online)
+		assert.Equal(t, 1.0, transitions)
+	})
+}
@@ -0,0 +1,125 @@
+package collector
+
+import (
+	"sync"
+	"sync/atomic"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+// Router forwards MQTT messages to the queues of the collectors handling their
+// topic. It never blocks the caller: a message for a full queue gets dropped
+// and counted, so a slow collector cannot stall the MQTT client.
+type Router struct {
+	log         *zap.Logger
+	queueSize   int
+	droppedDesc *prometheus.Desc
+	depthDesc   *prometheus.Desc
+	received    *prometheus.CounterVec
+	unhandled   *prometheus.CounterVec
+
+	mu     sync.RWMutex
+	closed bool
+	routes []*route
+}
+
+type route struct {
+	def     Definition
+	queue   chan mqtt.Message
+	dropped atomic.Uint64
+}
+
+// NewRouter creates a router with a queue of queueSize messages per collector.
+func NewRouter(log *zap.Logger, queueSize int) *Router {
+	return &Router{
+		log:         log,
+		queueSize:   queueSize,
+		droppedDesc: prometheus.NewDesc("shelly_exporter_dropped_messages_total", "messages dropped because the queue of the collector was full", []string{"collector"}, nil),
+		depthDesc:   prometheus.NewDesc("shelly_exporter_queue_depth", "messages waiting in the queue of the collector", []string{"collector"}, nil),
+		received: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "shelly_exporter_messages_received_total",
+			Help: "messages routed to a collector",
+		}, []string{"collector", "topic_class"}),
+		unhandled: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "shelly_exporter_unhandled_messages_total",
+			Help: "messages no enabled collector handles",
+		}, []string{"topic_class"}),
+	}
+}
+
+// Add creates the queue of the collector. The queue gets closed by Close.
+func (r *Router) Add(def Definition) <-chan mqtt.Message {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	rt := &route{def: def, queue: make(chan mqtt.Message, r.queueSize)}
+	r.routes = append(r.routes, rt)
+	return rt.queue
+}
+
+// Route forwards the message to all collectors handling its topic. After
+// Close messages get discarded.
+func (r *Router) Route(msg mqtt.Message) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if r.closed {
+		return
+	}
+	class := TopicClass(msg.Topic())
+	handled := false
+	for _, rt := range r.routes {
+		if !rt.def.HandlesTopic(msg.Topic()) {
+			continue
+		}
+		handled = true
+		r.received.WithLabelValues(rt.def.Name, class).Inc()
+		select {
+		case rt.queue <- msg:
+		default:
+			rt.dropped.Add(1)
+			r.log.Debug("queue full, message dropped",
+				zap.String("collector", rt.def.Name),
+				zap.String("topic", msg.Topic()))
+		}
+	}
+	if !handled {
+		r.unhandled.WithLabelValues(class).Inc()
+	}
+}
+
+// Close closes all queues. It waits for running Route calls, so it is safe to
+// call while the MQTT client still delivers messages.
+func (r *Router) Close() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.closed {
+		return
+	}
+	r.closed = true
+	for _, rt := range r.routes {
+		close(rt.queue)
+	}
+}
+
+func (r *Router) Describe(ch chan<- *prometheus.Desc) {
+	ch <- r.droppedDesc
+	ch <- r.depthDesc
+	r.received.Describe(ch)
+	r.unhandled.Describe(ch)
+}
+
+func (r *Router) Collect(ch chan<- prometheus.Metric) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, rt := range r.routes {
+		ch <- prometheus.MustNewConstMetric(r.droppedDesc, prometheus.CounterValue, float64(rt.dropped.Load()), rt.def.Name)
+		ch <- prometheus.MustNewConstMetric(r.depthDesc, prometheus.GaugeValue, float64(len(rt.queue)), rt.def.Name)
+	}
+	r.received.Collect(ch)
+	r.unhandled.Collect(ch)
+}
@@ -0,0 +1,87 @@
+package collector
+
+import (
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/SchumacherFM/prometheus_shelly_exporter/collector/collectortest"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestRouter(t *testing.T) {
+	r := NewRouter(zap.NewNop(), 2)
+	ht := r.Add(Definition{Name: "ht", Subscriptions: []string{"shellies/+/info"}})
+	rpc := r.Add(Definition{Name: "rpc", Subscriptions: []string{"+/events/rpc"}})
+
+	for i := 0; i < 3; i++ {
+		r.Route(collectortest.NewMessage("shellies/shellyht-1/info", "{}"))
+	}
+	r.Route(collectortest.NewMessage("shellyplus1-1/events/rpc", "{}"))
+	r.Route(collectortest.NewMessage("shellies/shellyht-1/online", "true"))
+
+	assert.Len(t, ht, 2)
+	assert.Len(t, rpc, 1)
+
+	err := testutil.CollectAndCompare(r, strings.NewReader(`
+# HELP shelly_exporter_dropped_messages_total messages dropped because the queue of the collector was full
+# TYPE shelly_exporter_dropped_messages_total counter
+shelly_exporter_dropped_messages_total{collector="ht"} 1
+shelly_exporter_dropped_messages_total{collector="rpc"} 0
+# HELP shelly_exporter_messages_received_total messages routed to a collector
+# TYPE shelly_exporter_messages_received_total counter
+shelly_exporter_messages_received_total{collector="ht",topic_class="gen1/info"} 3
+shelly_exporter_messages_received_total{collector="rpc",topic_class="gen2/events/rpc"} 1
+# HELP shelly_exporter_queue_depth messages waiting in the queue of the collector
+# TYPE shelly_exporter_queue_depth gauge
+shelly_exporter_queue_depth{collector="ht"} 2
+shelly_exporter_queue_depth{collector="rpc"} 1
+# HELP shelly_exporter_unhandled_messages_total messages no enabled collector handles
+# TYPE shelly_exporter_unhandled_messages_total counter
+shelly_exporter_unhandled_messages_total{topic_class="gen1/online"} 1
+`))
+	require.NoError(t, err)
+}
+
+func TestTopicClass(t *testing.T) {
+	for topic, want := range map[string]string{
+		"shellies/announce":                   "gen1/announce",
+		"shellies/shellyht-1/info":            "gen1/info",
+		"shellies/shellyem3-1/emeter/0/power": "gen1/emeter",
+		"shellyplus1-1/events/rpc":            "gen2/events/rpc",
+		"shellyplus1-1/online":                "gen2/online",
+		"shelly_exporter/rpc":                 "gen2/rpc",
+		"shellies/command":                    "gen1/command",
+		"shellies/x1":                         "other",
+		"shellies/shellyht-1/x1/0":            "other",
+		"zigbee2mqtt/bridge/state":            "other",
+	} {
+		assert.Equal(t, want, TopicClass(topic), topic)
+	}
+}
+
+func TestRouter_RouteAfterClose(t *testing.T) {
+	r := NewRouter(zap.NewNop(), 1)
+	q := r.Add(Definition{Name: "rpc", Subscriptions: []string{"#"}})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				r.Route(collectortest.NewMessage("a/events/rpc", ""))
+			}
+		}()
+	}
+	r.Close()
+	r.Close()
+	wg.Wait()
+
+	for range q {
+		// drain until closed
+	}
+}
@@ -0,0 +1,70 @@
+package collector
+
+import (
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+// Stats counts the payloads the collectors failed to parse.
+type Stats struct {
+	parseErrors *prometheus.CounterVec
+}
+
+func NewStats() *Stats {
+	return &Stats{
+		parseErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "shelly_exporter_parse_errors_total",
+			Help: "payloads a collector failed to parse",
+		}, []string{"collector"}),
+	}
+}
+
+func (s *Stats) Describe(ch chan<- *prometheus.Desc) {
+	s.parseErrors.Describe(ch)
+}
+
+func (s *Stats) Collect(ch chan<- prometheus.Metric) {
+	s.parseErrors.Collect(ch)
+}
+
+// ParseError logs a payload which could not be parsed and counts it for the
+// collector.
+func (o Options) ParseError(topic string, err error) {
+	o.Log.Error("failed to parse payload", zap.Error(err), zap.String("topic", topic))
+	if o.Stats != nil {
+		o.Stats.parseErrors.WithLabelValues(o.Name).Inc()
+	}
+}
+
+// gen1Kinds are the known <kind> of shellies/<id>/<kind>/..., topic levels of
+// other publishers must not become label values.
+var gen1Kinds = map[string]bool{
+	"adc": true, "command": true, "emeter": true,
+	"ext_humidity": true, "ext_switch": true, "ext_temperature": true,
+	"ext_temperature_f": true, "info": true, "input": true, "input_event": true,
+	"light": true, "online": true, "relay": true, "roller": true,
+	"sensor": true, "status": true,
+}
+
+// TopicClass reduces a topic to a small set of classes for the exporter's own
+// metrics: gen1/<kind> for shellies/<id>/<kind>/... of gen1Kinds,
+// gen1/announce, gen1/command, gen2/events/rpc, gen2/online, gen2/rpc or
+// other.
+func TopicClass(topic string) string {
+	levels := strings.Split(topic, "/")
+	switch {
+	case levels[0] == "shellies" && len(levels) == 2 && (levels[1] == "announce" || levels[1] == "command"):
+		return "gen1/" + levels[1]
+	case levels[0] == "shellies" && len(levels) > 2 && gen1Kinds[levels[2]]:
+		return "gen1/" + levels[2]
+	case strings.HasSuffix(topic, "/events/rpc"):
+		return "gen2/events/rpc"
+	case len(levels) == 2 && levels[1] == "online":
+		return "gen2/online"
+	case len(levels) == 2 && levels[1] == "rpc":
+		return "gen2/rpc"
+	}
+	return "other"
+}
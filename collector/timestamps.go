@@ -0,0 +1,116 @@
+package collector
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// minDeviceTime filters device clocks which are not synced yet, they count
+// from 0 or 2000-01-01 after a boot.
+var minDeviceTime = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// Timestamps keeps the time of the last reading per device of one collector,
+// Gen1 unixtime or Gen2 params.ts, otherwise the receive time. The time of a
+// device never goes backwards, so a delayed message cannot produce
+// out-of-order samples.
+type Timestamps struct {
+	now func() time.Time
+
+	mu   sync.Mutex
+	last map[string]time.Time // device label => time of the last reading
+}
+
+func NewTimestamps() *Timestamps {
+	return &Timestamps{
+		now:  time.Now,
+		last: make(map[string]time.Time),
+	}
+}
+
+// Observe records a reading of the device. deviceTime is the unix time
+// reported by the device in seconds, 0 if the message has none. Times before
+// 2020 or in the future fall back to the receive time. It is safe to call on a
+// nil Timestamps.
+func (t *Timestamps) Observe(device string, deviceTime float64) {
+	if t == nil || device == "" {
+		return
+	}
+	now := t.now()
+	ts := now
+	if deviceTime > 0 {
+		dt := time.Unix(0, int64(deviceTime*1e9))
+		if dt.After(minDeviceTime) && !dt.After(now) {
+			ts = dt
+		}
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if ts.After(t.last[device]) {
+		t.last[device] = ts
+	}
+}
+
+// Time returns the time of the last reading of the device.
+func (t *Timestamps) Time(device string) (time.Time, bool) {
+	if t == nil {
+		return time.Time{}, false
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	ts, ok := t.last[device]
+	return ts, ok
+}
+
+// WithTimestamps wraps a collector and attaches the time of the last reading
+// to each metric with a device or gateway label. The metrics of Health keep
+// the scrape time, a stale device with up 0 at the time of its last reading
+// would be dropped by Prometheus as too old. So do metrics without these
+// labels. A device which stays silent for hours keeps its old time, Prometheus
+// rejects these samples as out of bounds unless out_of_order_time_window
+// covers them.
+func WithTimestamps(c prometheus.Collector, t *Timestamps) prometheus.Collector {
+	return &timestampCollector{Collector: c, ts: t}
+}
+
+type timestampCollector struct {
+	prometheus.Collector
+	ts *Timestamps
+}
+
+func (c *timestampCollector) Collect(ch chan<- prometheus.Metric) {
+	metrics := make(chan prometheus.Metric)
+	go func() {
+		c.Collector.Collect(metrics)
+		close(metrics)
+	}()
+
+	for m := range metrics {
+		if _, ok := m.(healthMetric); ok {
+			ch <- m
+			continue
+		}
+		var pb dto.Metric
+		if err := m.Write(&pb); err != nil {
+			ch <- m // let the registry report the error
+			continue
+		}
+		var (
+			ts time.Time
+			ok bool
+		)
+		for _, lp := range pb.GetLabel() {
+			if lp.GetName() == "device" || lp.GetName() == "gateway" {
+				ts, ok = c.ts.Time(lp.GetValue())
+				break
+			}
+		}
+		if ok {
+			m = prometheus.NewMetricWithTimestamp(ts, m)
+		}
+		ch <- m
+	}
+}
@@ -0,0 +1,128 @@
+package collector
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTimestamps_Observe(t *testing.T) {
+	now := time.Date(2024, 2, 11, 9, 0, 0, 0, time.UTC)
+	ts := NewTimestamps()
+	ts.now = func() time.Time { return now }
+
+	ts.Observe("shellyht-1", float64(now.Add(-time.Hour).Unix()))
+	got, ok := ts.Time("shellyht-1")
+	require.True(t, ok)
+	assert.Equal(t, now.Add(-time.Hour), got.UTC(), "device time")
+
+	ts.Observe("shellyht-1", float64(now.Add(-2*time.Hour).Unix()))
+	got, _ = ts.Time("shellyht-1")
+	assert.Equal(t, now.Add(-time.Hour), got.UTC(), "never goes backwards")
+
+	ts.Observe("shellyht-2", 0)
+	got, _ = ts.Time("shellyht-2")
+	assert.Equal(t, now, got, "receive time without device time")
+
+	ts.Observe("shellyht-3", 3600) // clock not synced
+	got, _ = ts.Time("shellyht-3")
+	assert.Equal(t, now, got)
+
+	ts.Observe("shellyht-4", float64(now.Add(time.Hour).Unix()))
+	got, _ = ts.Time("shellyht-4")
+	assert.Equal(t, now, got, "no samples from the future")
+
+	var nilTS *Timestamps
+	nilTS.Observe("shellyht-1", 0)
+	_, ok = nilTS.Time("shellyht-1")
+	assert.False(t, ok)
+}
+
+func TestWithTimestamps(t *testing.T) {
+	now := time.Date(2024, 2, 11, 9, 0, 0, 0, time.UTC)
+	ts := NewTimestamps()
+	ts.now = func() time.Time { return now }
+	ts.Observe("shellyht-1", float64(now.Add(-time.Hour).Unix()))
+
+	g := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "shellyht_temperature", Help: "t"}, []string{"device"})
+	g.WithLabelValues("shellyht-1").Set(21)
+	g.WithLabelValues("shellyht-2").Set(22)
+
+	ch := make(chan prometheus.Metric, 2)
+	WithTimestamps(g, ts).Collect(ch)
+	close(ch)
+
+	got := map[string]int64{}
+	for m := range ch {
+		var pb dto.Metric
+		require.NoError(t, m.Write(&pb))
+		got[pb.GetLabel()[0].GetValue()] = pb.GetTimestampMs()
+	}
+	assert.Equal(t, map[string]int64{
+		"shellyht-1": now.Add(-time.Hour).UnixMilli(),
+		"shellyht-2": 0, // scrape time
+	}, got)
+}
+
+func TestWithTimestamps_health(t *testing.T) {
+	now := time.Date(2024, 2, 11, 9, 0, 0, 0, time.UTC)
+	ts := NewTimestamps()
+	ts.now = func() time.Time { return now }
+	ts.Observe("shellyht-1", float64(now.Add(-time.Hour).Unix()))
+
+	h := NewHealth("shellyht", Options{})
+	h.OK("shellyht-1")
+
+	ch := make(chan prometheus.Metric, 4)
+	WithTimestamps(h, ts).Collect(ch)
+	close(ch)
+
+	var n int
+	for m := range ch {
+		var pb dto.Metric
+		require.NoError(t, m.Write(&pb))
+		assert.Zero(t, pb.GetTimestampMs(), "scrape time for %s", m.Desc())
+		n++
+	}
+	assert.Equal(t, 4, n, "up and three scrape errors")
+}
+
+func TestTimestamps_sharedDevice(t *testing.T) {
+	// main.go creates one Timestamps per collector, a device seen by two
+	// collectors never goes backwards in either of them
+	now := time.Date(2024, 2, 11, 9, 0, 0, 0, time.UTC)
+	ht, devicepower := NewTimestamps(), NewTimestamps()
+	ht.now = func() time.Time { return now }
+	devicepower.now = func() time.Time { return now }
+
+	const device = "shellyhtg3-a8032ab12345"
+	ht.Observe(device, float64(now.Add(-time.Minute).Unix()))
+	devicepower.Observe(device, float64(now.Add(-time.Hour).Unix()))
+	ht.Observe(device, float64(now.Add(-2*time.Minute).Unix())) // delayed
+	devicepower.Observe(device, float64(now.Add(-2*time.Hour).Unix()))
+
+	temp := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "shellyht_temperature", Help: "t"}, []string{"device"})
+	temp.WithLabelValues(device).Set(21)
+	battery := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "shellydevicepower_battery_percent", Help: "b"}, []string{"device"})
+	battery.WithLabelValues(device).Set(80)
+
+	for _, tt := range []struct {
+		c    prometheus.Collector
+		ts   *Timestamps
+		want time.Time
+	}{
+		{temp, ht, now.Add(-time.Minute)},
+		{battery, devicepower, now.Add(-time.Hour)},
+	} {
+		ch := make(chan prometheus.Metric, 1)
+		WithTimestamps(tt.c, tt.ts).Collect(ch)
+		close(ch)
+		var pb dto.Metric
+		require.NoError(t, (<-ch).Write(&pb))
+		assert.Equal(t, tt.want.UnixMilli(), pb.GetTimestampMs())
+	}
+}
@@ -0,0 +1,296 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/SchumacherFM/prometheus_shelly_exporter/collector"
+	"github.com/SchumacherFM/prometheus_shelly_exporter/devicemap"
+	"gopkg.in/yaml.v3"
+)
+
+// Config is the content of the --config.file. Flags set on the command line
+// take precedence, empty fields fall back to the flag defaults. Subscriptions,
+// see ParseSubscription, override the topics of the enabled collectors.
+//
+//	mqtt:
+//	  urls: [mqtt://broker:1883]
+//	  username: exporter
+//	  password: secret
+//	  client_id: shelly-exporter
+//	  keepalive: 30s
+//	  clean_session: false
+//	  connect_timeout: 30s
+//	  version: 5
+//	  share_group: exporters
+//	  session_expiry: 1h
+//	  tls:
+//	    ca_file: /etc/shelly_exporter/ca.pem
+//	    cert_file: /etc/shelly_exporter/client.pem
+//	    key_file: /etc/shelly_exporter/client-key.pem
+//	  websocket:
+//	    headers:
+//	      Authorization: Bearer secret
+//	    proxy: http://proxy:3128
+//	subscriptions:
+//	  - shellies/#
+//	  - +/events/rpc@1
+//	collectors:
+//	  threeem:
+//	    enabled: false
+//	  ht:
+//	    stale_after: 6h
+//	  inventory:
+//	    requests: true
+//	    request_interval: 1h
+//	devices:
+//	  - id: shellyem3-washtumbler
+//	    name: Washing machine
+//	output:
+//	  listen_address: :9784
+//	  metrics_path: /metrics
+//	  exporter_metrics: true
+//	  queue_size: 100
+//	  device_timestamps: false
+type Config struct {
+	MQTT          MQTT                 `yaml:"mqtt"`
+	Subscriptions []string             `yaml:"subscriptions"`
+	Collectors    map[string]Collector `yaml:"collectors"`
+	Devices       []devicemap.Device   `yaml:"devices"`
+	Output        Output               `yaml:"output"`
+}
+
+type MQTT struct {
+	URLs      []string  `yaml:"urls"`
+	Username  string    `yaml:"username"`
+	Password  string    `yaml:"password"`
+	TLS       TLS       `yaml:"tls"`
+	WebSocket WebSocket `yaml:"websocket"`
+	// ClientID is empty by default and the broker picks one. Each exporter of
+	// a share group needs its own.
+	ClientID  string        `yaml:"client_id"`
+	KeepAlive time.Duration `yaml:"keepalive"`
+	// CleanSession false keeps the subscriptions and queued messages on the
+	// broker while the exporter is disconnected, it needs a client_id. Unset
+	// is true.
+	CleanSession   *bool         `yaml:"clean_session"`
+	ConnectTimeout time.Duration `yaml:"connect_timeout"`
+	// Version is the MQTT protocol, 3 for 3.1.1 (default) or 5.
+	Version int `yaml:"version"`
+	// ShareGroup subscribes to $share/<group>/<topic> so the exporters of the
+	// group split the messages, MQTT 5 only.
+	ShareGroup string `yaml:"share_group"`
+	// UserProperties are sent with CONNECT, SUBSCRIBE and PUBLISH, MQTT 5
+	// only.
+	UserProperties map[string]string `yaml:"user_properties"`
+	// SessionExpiry keeps the session on the broker this long after a
+	// disconnect, MQTT 5 only.
+	SessionExpiry time.Duration `yaml:"session_expiry"`
+}
+
+// PersistentSession reports if clean_session is false.
+func (m MQTT) PersistentSession() bool {
+	return m.CleanSession != nil && !*m.CleanSession
+}
+
+// Subscription is a topic filter and the QoS to subscribe with.
+type Subscription struct {
+	Topic string
+	QoS   byte
+}
+
+// ParseSubscription parses a topic filter with an optional @qos suffix, e.g.
+// shellies/+/info@1. Without a suffix the QoS is 0.
+func ParseSubscription(s string) (Subscription, error) {
+	sub := Subscription{Topic: s}
+	if i := strings.LastIndexByte(s, '@'); i >= 0 {
+		switch s[i+1:] {
+		case "0", "1", "2":
+			sub.Topic, sub.QoS = s[:i], s[i+1]-'0'
+		default:
+			return sub, fmt.Errorf("%q: QoS after @ must be 0, 1 or 2", s)
+		}
+	}
+	if !collector.ValidTopicFilter(sub.Topic) {
+		return sub, fmt.Errorf("invalid topic filter %q", sub.Topic)
+	}
+	return sub, nil
+}
+
+// String returns the subscription in the format of ParseSubscription.
+func (s Subscription) String() string {
+	if s.QoS == 0 {
+		return s.Topic
+	}
+	return fmt.Sprintf("%s@%d", s.Topic, s.QoS)
+}
+
+// WebSocket configures ws:// and wss:// URLs, the path of the URL is the one
+// of the HTTP upgrade request.
+type WebSocket struct {
+	// Headers are sent with the upgrade request, e.g. an Authorization token
+	// for a reverse proxy.
+	Headers map[string]string `yaml:"headers"`
+	// Proxy is the URL of an HTTP proxy, default $HTTPS_PROXY or $HTTP_PROXY.
+	Proxy string `yaml:"proxy"`
+}
+
+// TLS configures mqtts:// and ssl:// connections. Without a CA file the
+// system roots verify the broker, cert and key enable mutual TLS.
+type TLS struct {
+	CAFile   string `yaml:"ca_file"`
+	CertFile string `yaml:"cert_file"`
+	KeyFile  string `yaml:"key_file"`
+	// ServerName overrides the host name of the URL for SNI and the
+	// verification of the broker certificate.
+	ServerName string `yaml:"server_name"`
+	// InsecureSkipVerify accepts any broker certificate, for testing only.
+	InsecureSkipVerify bool `yaml:"insecure_skip_verify"`
+}
+
+// Collector holds the options of one device collector.
+type Collector struct {
+	// Enabled overrides the default of the collector if set.
+	Enabled *bool `yaml:"enabled"`
+	// StaleAfter sets <prefix>_up of a device to 0 if it sent no reading for
+	// this long, 0 disables it.
+	StaleAfter time.Duration `yaml:"stale_after"`
+	// Requests lets a collector which supports it publish requests to the
+	// devices, e.g. the inventory asks for the model. Off if nil.
+	Requests *bool `yaml:"requests"`
+	// RequestInterval is the minimum time between two requests to the same
+	// device.
+	RequestInterval time.Duration `yaml:"request_interval"`
+}
+
+type Output struct {
+	ListenAddress   string `yaml:"listen_address"`
+	MetricsPath     string `yaml:"metrics_path"`
+	ExporterMetrics *bool  `yaml:"exporter_metrics"`
+	// QueueSize is the number of messages buffered per collector, at least 1.
+	QueueSize *int `yaml:"queue_size"`
+	// DeviceTimestamps attaches the time of the last reading to the samples.
+	DeviceTimestamps *bool `yaml:"device_timestamps"`
+}
+
+// Load reads, parses and validates the file.
+func Load(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("config: %w", err)
+	}
+	cfg, err := Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+// Parse decodes and validates the YAML, unknown fields are an error.
+func Parse(data []byte) (*Config, error) {
+	var cfg Config
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&cfg); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("config: %w", err)
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return &cfg, nil
+}
+
+// Validate checks the fields which are set, it allows an empty config.
+func (cfg *Config) Validate() error {
+	var errs []error
+	for _, u := range cfg.MQTT.URLs {
+		if pu, err := url.Parse(u); err != nil {
+			errs = append(errs, fmt.Errorf("mqtt.urls: %w", err))
+		} else if pu.Scheme == "" || pu.Host == "" {
+			errs = append(errs, fmt.Errorf("mqtt.urls: %q must look like mqtt://hostname:port", u))
+		}
+	}
+	switch cfg.MQTT.Version {
+	case 0, 3:
+		if cfg.MQTT.ShareGroup != "" || len(cfg.MQTT.UserProperties) > 0 || cfg.MQTT.SessionExpiry != 0 {
+			errs = append(errs, errors.New("mqtt: share_group, user_properties and session_expiry need version 5"))
+		}
+	case 5:
+	default:
+		errs = append(errs, fmt.Errorf("mqtt.version: %d must be 3 or 5", cfg.MQTT.Version))
+	}
+	if strings.ContainsAny(cfg.MQTT.ShareGroup, "/+#") {
+		errs = append(errs, fmt.Errorf("mqtt.share_group: %q must not contain /, + or #", cfg.MQTT.ShareGroup))
+	}
+	if cfg.MQTT.SessionExpiry < 0 {
+		errs = append(errs, errors.New("mqtt.session_expiry: must not be negative"))
+	}
+	if cfg.MQTT.KeepAlive < 0 || cfg.MQTT.ConnectTimeout < 0 {
+		errs = append(errs, errors.New("mqtt: keepalive and connect_timeout must not be negative"))
+	}
+	if cfg.MQTT.PersistentSession() {
+		if cfg.MQTT.ClientID == "" {
+			errs = append(errs, errors.New("mqtt.clean_session: false needs a client_id"))
+		}
+		if cfg.MQTT.Version == 5 && cfg.MQTT.SessionExpiry == 0 {
+			errs = append(errs, errors.New("mqtt.clean_session: false needs a session_expiry with version 5"))
+		}
+	}
+	for name := range cfg.MQTT.WebSocket.Headers {
+		if name == "" || strings.ContainsAny(name, ": \t\r\n") {
+			errs = append(errs, fmt.Errorf("mqtt.websocket.headers: invalid header name %q", name))
+		}
+	}
+	if p := cfg.MQTT.WebSocket.Proxy; p != "" {
+		if pu, err := url.Parse(p); err != nil {
+			errs = append(errs, fmt.Errorf("mqtt.websocket.proxy: %w", err))
+		} else if pu.Scheme == "" || pu.Host == "" {
+			errs = append(errs, fmt.Errorf("mqtt.websocket.proxy: %q must look like http://hostname:port", p))
+		}
+	}
+	if (cfg.MQTT.TLS.CertFile == "") != (cfg.MQTT.TLS.KeyFile == "") {
+		errs = append(errs, errors.New("mqtt.tls: cert_file and key_file must be set together"))
+	}
+	for _, topic := range cfg.Subscriptions {
+		if _, err := ParseSubscription(topic); err != nil {
+			errs = append(errs, fmt.Errorf("subscriptions: %w", err))
+		}
+	}
+
+	known := make(map[string]collector.Definition)
+	for _, def := range collector.Definitions() {
+		known[def.Name] = def
+	}
+	for name, c := range cfg.Collectors {
+		def, ok := known[name]
+		if !ok {
+			errs = append(errs, fmt.Errorf("collectors: unknown collector %q", name))
+		}
+		if ok && !def.Requests && (c.Requests != nil || c.RequestInterval != 0) {
+			errs = append(errs, fmt.Errorf("collectors.%s: the collector sends no requests", name))
+		}
+		if c.RequestInterval < 0 {
+			errs = append(errs, fmt.Errorf("collectors.%s.request_interval: must not be negative", name))
+		}
+		if c.StaleAfter < 0 {
+			errs = append(errs, fmt.Errorf("collectors.%s.stale_after: must not be negative", name))
+		}
+	}
+	if _, err := devicemap.New(cfg.Devices); err != nil {
+		errs = append(errs, fmt.Errorf("devices: %w", err))
+	}
+	if q := cfg.Output.QueueSize; q != nil && *q < 1 {
+		errs = append(errs, errors.New("output.queue_size: must be positive"))
+	}
+
+	if err := errors.Join(errs...); err != nil {
+		return fmt.Errorf("config: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,115 @@
+package config
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/SchumacherFM/prometheus_shelly_exporter/collector"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func init() {
+	for _, name := range []string{"ht", "threeem", "inventory"} {
+		collector.Register(collector.Definition{
+			Name:     name,
+			Requests: name == "inventory",
+			New: func(context.Context, <-chan mqtt.Message, collector.Options) prometheus.Collector {
+				return nil
+			},
+		})
+	}
+}
+
+func TestLoad(t *testing.T) {
+	cfg, err := Load("testdata/config.yaml")
+	require.NoError(t, err)
+
+	clean := false
+	assert.Equal(t, MQTT{
+		URLs:     []string{"mqtt://broker:1883"},
+		Username: "exporter",
+		Password: "secret",
+		TLS:      TLS{CAFile: "ca.pem", ServerName: "broker.internal"},
+		WebSocket: WebSocket{
+			Headers: map[string]string{"Authorization": "Bearer secret"},
+			Proxy:   "http://proxy:3128",
+		},
+		ClientID:       "shelly-exporter",
+		KeepAlive:      time.Minute,
+		CleanSession:   &clean,
+		ConnectTimeout: 5 * time.Second,
+	}, cfg.MQTT)
+	assert.True(t, cfg.MQTT.PersistentSession())
+	assert.Equal(t, []string{"shellies/#", "+/events/rpc@1"}, cfg.Subscriptions)
+	require.NotNil(t, cfg.Collectors["threeem"].Enabled)
+	assert.False(t, *cfg.Collectors["threeem"].Enabled)
+	assert.Nil(t, cfg.Collectors["ht"].Enabled)
+	assert.Equal(t, 6*time.Hour, cfg.Collectors["ht"].StaleAfter)
+	assert.Nil(t, cfg.Collectors["ht"].Requests)
+	require.NotNil(t, cfg.Collectors["inventory"].Requests)
+	assert.True(t, *cfg.Collectors["inventory"].Requests)
+	assert.Equal(t, 30*time.Minute, cfg.Collectors["inventory"].RequestInterval)
+	assert.Equal(t, "Washing machine", cfg.Devices[0].Name)
+	assert.Equal(t, ":9784", cfg.Output.ListenAddress)
+	assert.True(t, *cfg.Output.ExporterMetrics)
+	require.NotNil(t, cfg.Output.QueueSize)
+	assert.Equal(t, 50, *cfg.Output.QueueSize)
+}
+
+func TestParse_invalid(t *testing.T) {
+	tests := map[string]string{
+		"unknown field":     "output:\n  listen_adress: :80\n",
+		"url":               "mqtt:\n  urls: [broker]\n",
+		"tls key":           "mqtt:\n  tls: {cert_file: client.pem}\n",
+		"ws header":         "mqtt:\n  websocket: {headers: {'X Token': a}}\n",
+		"ws proxy":          "mqtt:\n  websocket: {proxy: proxy}\n",
+		"version":           "mqtt:\n  version: 4\n",
+		"share group v3":    "mqtt:\n  share_group: exporters\n",
+		"share group":       "mqtt:\n  version: 5\n  share_group: a/b\n",
+		"keepalive":         "mqtt:\n  keepalive: -1s\n",
+		"session client id": "mqtt:\n  clean_session: false\n",
+		"session expiry":    "mqtt:\n  version: 5\n  client_id: a\n  clean_session: false\n",
+		"topic":             "subscriptions: [a/#/b]\n",
+		"qos":               "subscriptions: [a/b@3]\n",
+		"unknown collector": "collectors:\n  nope: {enabled: true}\n",
+		"stale after":       "collectors:\n  ht: {stale_after: -1s}\n",
+		"requests":          "collectors:\n  ht: {requests: true}\n",
+		"request interval":  "collectors:\n  inventory: {request_interval: -1s}\n",
+		"devices":           "devices:\n  - name: no id\n",
+		"queue size":        "output:\n  queue_size: -1\n",
+		"queue size zero":   "output:\n  queue_size: 0\n",
+	}
+	for name, data := range tests {
+		_, err := Parse([]byte(data))
+		assert.Error(t, err, name)
+	}
+
+	cfg, err := Parse(nil)
+	require.NoError(t, err, "empty config")
+	assert.Empty(t, cfg.MQTT.URLs)
+}
+
+func TestParseSubscription(t *testing.T) {
+	tests := map[string]Subscription{
+		"shellies/+/info":       {Topic: "shellies/+/info"},
+		"shellies/+/info@0":     {Topic: "shellies/+/info"},
+		"shellies/+/emeter/#@1": {Topic: "shellies/+/emeter/#", QoS: 1},
+		"+/events/rpc@2":        {Topic: "+/events/rpc", QoS: 2},
+	}
+	for s, want := range tests {
+		got, err := ParseSubscription(s)
+		require.NoError(t, err, s)
+		assert.Equal(t, want, got, s)
+	}
+	assert.Equal(t, "+/events/rpc@2", Subscription{Topic: "+/events/rpc", QoS: 2}.String())
+	assert.Equal(t, "+/events/rpc", Subscription{Topic: "+/events/rpc"}.String())
+
+	for _, s := range []string{"a/b@", "a/b@3", "a/b@qos", "@1", "a/#/b@1"} {
+		_, err := ParseSubscription(s)
+		assert.Error(t, err, s)
+	}
+}
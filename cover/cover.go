@@ -0,0 +1,322 @@
+package cover
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/SchumacherFM/prometheus_shelly_exporter/collector"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/tidwall/gjson"
+	"go.uber.org/zap"
+)
+
+// Gen2 roller shutters (Plus 2PM in cover mode, Pro Dual Cover) report the
+// component cover:<N> in the RPC status notifications:
+//
+//	"cover:0": {"id":0,"source":"rpc","state":"opening","apower":85.3,"voltage":231.2,
+//	  "current":0.41,"pf":0.9,"freq":50,"aenergy":{"total":2.435},
+//	  "temperature":{"tC":36.9,"tF":98.5},"pos_control":true,"current_pos":42,"target_pos":100}
+//
+// NotifyStatus only carries the changed fields, so the values get merged per
+// cover.
+//
+// Gen1 rollers (Shelly 2/2.5 in roller mode) publish one topic per value:
+//
+//	shellies/<id>/roller/<N>         open|close|stop, the last direction
+//	shellies/<id>/roller/<N>/pos     0-100, -1 if not calibrated
+//	shellies/<id>/roller/<N>/power   W
+//	shellies/<id>/roller/<N>/energy  Watt-minute
+//
+// They get mapped onto the fields of the Gen2 cover. Any other state counts as
+// a parse error and sets the state unknown.
+var states = []string{"unknown", "open", "closed", "opening", "closing", "stopped", "calibrating"}
+
+// gen1States maps the payload of shellies/<id>/roller/<N> to states.
+var gen1States = map[string]string{"open": "opening", "close": "closing", "stop": "stopped"}
+
+type cover struct {
+	state  string
+	values map[string]float64 // gjson path => value
+}
+
+type Collector struct {
+	opts        Options
+	posDesc     *prometheus.Desc
+	targetDesc  *prometheus.Desc
+	stateDesc   *prometheus.Desc
+	powerDesc   *prometheus.Desc
+	voltageDesc *prometheus.Desc
+	currentDesc *prometheus.Desc
+	pfDesc      *prometheus.Desc
+	energyDesc  *prometheus.Desc
+	tmpDesc     *prometheus.Desc
+	health      *collector.Health
+	fieldDescs  map[string]*prometheus.Desc // gjson path => desc
+
+	mu      sync.Mutex
+	devices map[string]map[string]*cover // device ID => cover ID => state
+}
+
+type Options struct {
+	collector.Options
+	TestCB func()
+}
+
+func init() {
+	collector.Register(collector.Definition{
+		Name:          "cover",
+		Help:          "Gen1 rollers and Gen2 covers",
+		Default:       true,
+		Subscriptions: []string{"shellies/+/roller/#", "+/events/rpc"},
+		New: func(ctx context.Context, messageChan <-chan mqtt.Message, opts collector.Options) prometheus.Collector {
+			return NewCollector(ctx, messageChan, Options{Options: opts})
+		},
+	})
+}
+
+func NewCollector(ctx context.Context, messageChan <-chan mqtt.Message, opts Options) *Collector {
+	c := &Collector{
+		opts:        opts,
+		posDesc:     prometheus.NewDesc("shellycover_position", "current position in percent, 0 is fully closed", []string{"device", "cover"}, nil),
+		targetDesc:  prometheus.NewDesc("shellycover_target_position", "target position in percent while the cover moves", []string{"device", "cover"}, nil),
+		stateDesc:   prometheus.NewDesc("shellycover_state", "movement state, 1 for the current state", []string{"device", "cover", "state"}, nil),
+		powerDesc:   prometheus.NewDesc("shellycover_power", "instantaneous active power in Watts", []string{"device", "cover"}, nil),
+		voltageDesc: prometheus.NewDesc("shellycover_voltage", "supply voltage in Volts", []string{"device", "cover"}, nil),
+		currentDesc: prometheus.NewDesc("shellycover_current", "current in Amps", []string{"device", "cover"}, nil),
+		pfDesc:      prometheus.NewDesc("shellycover_pf", "power factor (dimensionless)", []string{"device", "cover"}, nil),
+		energyDesc:  prometheus.NewDesc("shellycover_energy_total", "total energy consumed in Wh", []string{"device", "cover"}, nil),
+		tmpDesc:     prometheus.NewDesc("shellycover_temperature", "internal device temperature", []string{"device", "cover", "unit"}, nil),
+		health:      collector.NewHealth("shellycover", opts.Options),
+		devices:     make(map[string]map[string]*cover),
+	}
+	c.fieldDescs = map[string]*prometheus.Desc{
+		"current_pos":    c.posDesc,
+		"target_pos":     c.targetDesc,
+		"apower":         c.powerDesc,
+		"voltage":        c.voltageDesc,
+		"current":        c.currentDesc,
+		"pf":             c.pfDesc,
+		"aenergy.total":  c.energyDesc,
+		"temperature.tC": c.tmpDesc,
+		"temperature.tF": c.tmpDesc,
+	}
+
+	go func() {
+		for {
+			select {
+			case msg, ok := <-messageChan:
+				if !ok {
+					if opts.TestCB != nil {
+						opts.TestCB()
+					}
+					return
+				}
+				switch topic := msg.Topic(); {
+				case strings.HasPrefix(topic, "shellies/"):
+					c.handleGen1(topic, msg.Payload())
+				case strings.HasSuffix(topic, "/rpc"):
+					c.handle(topic, msg.Payload())
+				}
+
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return c
+}
+
+// cover returns the state of the cover and creates it if needed. The caller
+// must hold c.mu.
+func (c *Collector) cover(devID, coverID string) *cover {
+	covers, ok := c.devices[devID]
+	if !ok {
+		covers = make(map[string]*cover)
+		c.devices[devID] = covers
+	}
+	cv, ok := covers[coverID]
+	if !ok {
+		cv = &cover{values: make(map[string]float64)}
+		covers[coverID] = cv
+	}
+	return cv
+}
+
+func (c *Collector) handleGen1(topic string, payload []byte) {
+	// shellies/<id>/roller/0 or shellies/<id>/roller/0/pos
+	topicPaths := strings.Split(topic, "/")
+	if len(topicPaths) < 4 || len(topicPaths) > 5 || topicPaths[2] != "roller" {
+		return
+	}
+	devID, coverID, value := topicPaths[1], topicPaths[3], strings.TrimSpace(string(payload))
+
+	var field string
+	if len(topicPaths) == 5 {
+		switch topicPaths[4] {
+		case "pos":
+			field = "current_pos"
+		case "power":
+			field = "apower"
+		case "energy":
+			field = "aenergy.total"
+		default:
+			return
+		}
+	}
+	var f64 float64
+	if field != "" {
+		var err error
+		if f64, err = strconv.ParseFloat(value, 64); err != nil {
+			c.health.ParseError(devID, topic, err)
+			return
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	cv := c.cover(devID, coverID)
+	switch field {
+	case "":
+		state, ok := gen1States[value]
+		if !ok {
+			cv.state = "unknown"
+			c.health.ParseError(devID, topic, fmt.Errorf("cover: unknown roller state %q", value))
+			return
+		}
+		cv.state = state
+	case "current_pos":
+		if f64 < 0 {
+			// not calibrated
+			delete(cv.values, field)
+		} else {
+			cv.values[field] = f64
+		}
+	case "aenergy.total":
+		cv.values[field] = f64 / 60
+	default:
+		cv.values[field] = f64
+	}
+	c.opts.Timestamps.Observe(devID, 0)
+	c.health.OK(devID)
+
+	c.opts.Log.Debug("message from mqtt",
+		zap.String("topic", topic),
+		zap.Int("length", len(payload)))
+}
+
+func (c *Collector) handle(topic string, payload []byte) {
+	r := gjson.ParseBytes(payload)
+	if m := r.Get("method").String(); m != "NotifyStatus" && m != "NotifyFullStatus" {
+		return
+	}
+	devID := r.Get("src").String()
+	if devID == "" {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var found bool
+	var err error
+	r.Get("params").ForEach(func(key, value gjson.Result) bool {
+		coverID, ok := strings.CutPrefix(key.String(), "cover:")
+		if !ok {
+			return true
+		}
+		found = true
+
+		cv := c.cover(devID, coverID)
+		if v := value.Get("state"); v.Exists() {
+			cv.state = v.String()
+			if !slices.Contains(states, cv.state) {
+				err = fmt.Errorf("cover: unknown state %q of cover:%s", cv.state, coverID)
+				cv.state = "unknown"
+			}
+		}
+		for field := range c.fieldDescs {
+			v := value.Get(field)
+			switch {
+			case !v.Exists():
+				// not part of this NotifyStatus
+			case v.Type == gjson.Null:
+				// e.g. target_pos once the cover stopped or an uncalibrated position
+				delete(cv.values, field)
+			default:
+				cv.values[field] = v.Float()
+			}
+		}
+		return true
+	})
+
+	if err != nil {
+		c.health.ParseError(devID, topic, err)
+		return
+	}
+	if found {
+		c.opts.Timestamps.Observe(devID, r.Get("params.ts").Float())
+		c.health.OK(devID)
+		c.opts.Log.Debug("message from mqtt",
+			zap.String("topic", topic),
+			zap.Int("length", len(payload)))
+	}
+}
+
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.posDesc
+	ch <- c.targetDesc
+	ch <- c.stateDesc
+	ch <- c.powerDesc
+	ch <- c.voltageDesc
+	ch <- c.currentDesc
+	ch <- c.pfDesc
+	ch <- c.energyDesc
+	ch <- c.tmpDesc
+	c.health.Describe(ch)
+}
+
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	c.collect(ch)
+	c.health.Collect(ch)
+}
+
+func (c *Collector) collect(ch chan<- prometheus.Metric) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for devID, covers := range c.devices {
+		if c.opts.Presence.Offline(devID) {
+			continue
+		}
+		for coverID, cv := range covers {
+			if cv.state != "" {
+				for _, state := range states {
+					var v float64
+					if state == cv.state {
+						v = 1
+					}
+					ch <- prometheus.MustNewConstMetric(c.stateDesc, prometheus.GaugeValue, v, devID, coverID, state)
+				}
+			}
+			for field, v := range cv.values {
+				switch field {
+				case "temperature.tC":
+					ch <- prometheus.MustNewConstMetric(c.tmpDesc, prometheus.GaugeValue, v, devID, coverID, "c")
+				case "temperature.tF":
+					ch <- prometheus.MustNewConstMetric(c.tmpDesc, prometheus.GaugeValue, v, devID, coverID, "f")
+				case "aenergy.total":
+					ch <- prometheus.MustNewConstMetric(c.energyDesc, prometheus.CounterValue, v, devID, coverID)
+				default:
+					ch <- prometheus.MustNewConstMetric(c.fieldDescs[field], prometheus.GaugeValue, v, devID, coverID)
+				}
+			}
+		}
+	}
+}
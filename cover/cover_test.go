@@ -0,0 +1,149 @@
+package cover
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/SchumacherFM/prometheus_shelly_exporter/collector"
+	"github.com/SchumacherFM/prometheus_shelly_exporter/collector/collectortest"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+var _ prometheus.Collector = (*Collector)(nil)
+
+func TestCollector_collect(t *testing.T) {
+	ctx := context.Background()
+	msgChan := make(chan mqtt.Message)
+
+	log, _ := zap.NewDevelopment(zap.Development())
+	msgGoRoutineDone := make(chan struct{})
+	c := NewCollector(ctx, msgChan, Options{
+		Options: collector.Options{Log: log},
+		TestCB: func() {
+			close(msgGoRoutineDone)
+		},
+	})
+
+	collectortest.SendFile(t, msgChan, "testdata/cover.txt")
+	close(msgChan)
+	<-msgGoRoutineDone
+
+	err := testutil.CollectAndCompare(c, strings.NewReader(`
+# HELP shellycover_current current in Amps
+# TYPE shellycover_current gauge
+shellycover_current{cover="0",device="shellyplus2pm-a8032ab12345"} 0.41
+# HELP shellycover_energy_total total energy consumed in Wh
+# TYPE shellycover_energy_total counter
+shellycover_energy_total{cover="0",device="shellyplus2pm-a8032ab12345"} 2.435
+shellycover_energy_total{cover="0",device="shellyswitch25-98CDAC1F2A3B"} 121
+# HELP shellycover_position current position in percent, 0 is fully closed
+# TYPE shellycover_position gauge
+shellycover_position{cover="0",device="shellyplus2pm-a8032ab12345"} 42
+shellycover_position{cover="0",device="shellyplus2pm-b0b21c123456"} 17
+shellycover_position{cover="0",device="shellyswitch25-98CDAC1F2A3B"} 42
+shellycover_position{cover="1",device="shellyprodualcovpm-a8032ab67890"} 100
+# HELP shellycover_power instantaneous active power in Watts
+# TYPE shellycover_power gauge
+shellycover_power{cover="0",device="shellyplus2pm-a8032ab12345"} 85.3
+shellycover_power{cover="0",device="shellyswitch25-98CDAC1F2A3B"} 0
+# HELP shellycover_scrape_errors_total readings of the device which failed
+# TYPE shellycover_scrape_errors_total counter
+shellycover_scrape_errors_total{device="shellyplus2pm-a8032ab12345",reason="invalid_reading"} 0
+shellycover_scrape_errors_total{device="shellyplus2pm-a8032ab12345",reason="parse_error"} 0
+shellycover_scrape_errors_total{device="shellyplus2pm-a8032ab12345",reason="stale"} 0
+shellycover_scrape_errors_total{device="shellyplus2pm-b0b21c123456",reason="invalid_reading"} 0
+shellycover_scrape_errors_total{device="shellyplus2pm-b0b21c123456",reason="parse_error"} 1
+shellycover_scrape_errors_total{device="shellyplus2pm-b0b21c123456",reason="stale"} 0
+shellycover_scrape_errors_total{device="shellyprodualcovpm-a8032ab67890",reason="invalid_reading"} 0
+shellycover_scrape_errors_total{device="shellyprodualcovpm-a8032ab67890",reason="parse_error"} 0
+shellycover_scrape_errors_total{device="shellyprodualcovpm-a8032ab67890",reason="stale"} 0
+shellycover_scrape_errors_total{device="shellyswitch25-98CDAC1F2A3B",reason="invalid_reading"} 0
+shellycover_scrape_errors_total{device="shellyswitch25-98CDAC1F2A3B",reason="parse_error"} 0
+shellycover_scrape_errors_total{device="shellyswitch25-98CDAC1F2A3B",reason="stale"} 0
+shellycover_scrape_errors_total{device="shellyswitch25-C45BBE6FDA5D",reason="invalid_reading"} 0
+shellycover_scrape_errors_total{device="shellyswitch25-C45BBE6FDA5D",reason="parse_error"} 1
+shellycover_scrape_errors_total{device="shellyswitch25-C45BBE6FDA5D",reason="stale"} 0
+shellycover_scrape_errors_total{device="shellyswitch25-E8DB84A1B2C3",reason="invalid_reading"} 0
+shellycover_scrape_errors_total{device="shellyswitch25-E8DB84A1B2C3",reason="parse_error"} 1
+shellycover_scrape_errors_total{device="shellyswitch25-E8DB84A1B2C3",reason="stale"} 0
+# HELP shellycover_state movement state, 1 for the current state
+# TYPE shellycover_state gauge
+shellycover_state{cover="0",device="shellyplus2pm-a8032ab12345",state="calibrating"} 0
+shellycover_state{cover="0",device="shellyplus2pm-a8032ab12345",state="closed"} 0
+shellycover_state{cover="0",device="shellyplus2pm-a8032ab12345",state="closing"} 1
+shellycover_state{cover="0",device="shellyplus2pm-a8032ab12345",state="open"} 0
+shellycover_state{cover="0",device="shellyplus2pm-a8032ab12345",state="opening"} 0
+shellycover_state{cover="0",device="shellyplus2pm-a8032ab12345",state="stopped"} 0
+shellycover_state{cover="0",device="shellyplus2pm-a8032ab12345",state="unknown"} 0
+shellycover_state{cover="0",device="shellyplus2pm-b0b21c123456",state="calibrating"} 0
+shellycover_state{cover="0",device="shellyplus2pm-b0b21c123456",state="closed"} 0
+shellycover_state{cover="0",device="shellyplus2pm-b0b21c123456",state="closing"} 0
+shellycover_state{cover="0",device="shellyplus2pm-b0b21c123456",state="open"} 0
+shellycover_state{cover="0",device="shellyplus2pm-b0b21c123456",state="opening"} 0
+shellycover_state{cover="0",device="shellyplus2pm-b0b21c123456",state="stopped"} 0
+shellycover_state{cover="0",device="shellyplus2pm-b0b21c123456",state="unknown"} 1
+shellycover_state{cover="0",device="shellyswitch25-98CDAC1F2A3B",state="calibrating"} 0
+shellycover_state{cover="0",device="shellyswitch25-98CDAC1F2A3B",state="closed"} 0
+shellycover_state{cover="0",device="shellyswitch25-98CDAC1F2A3B",state="closing"} 0
+shellycover_state{cover="0",device="shellyswitch25-98CDAC1F2A3B",state="open"} 0
+shellycover_state{cover="0",device="shellyswitch25-98CDAC1F2A3B",state="opening"} 0
+shellycover_state{cover="0",device="shellyswitch25-98CDAC1F2A3B",state="stopped"} 1
+shellycover_state{cover="0",device="shellyswitch25-98CDAC1F2A3B",state="unknown"} 0
+shellycover_state{cover="0",device="shellyswitch25-C45BBE6FDA5D",state="calibrating"} 0
+shellycover_state{cover="0",device="shellyswitch25-C45BBE6FDA5D",state="closed"} 0
+shellycover_state{cover="0",device="shellyswitch25-C45BBE6FDA5D",state="closing"} 1
+shellycover_state{cover="0",device="shellyswitch25-C45BBE6FDA5D",state="open"} 0
+shellycover_state{cover="0",device="shellyswitch25-C45BBE6FDA5D",state="opening"} 0
+shellycover_state{cover="0",device="shellyswitch25-C45BBE6FDA5D",state="stopped"} 0
+shellycover_state{cover="0",device="shellyswitch25-C45BBE6FDA5D",state="unknown"} 0
+shellycover_state{cover="0",device="shellyswitch25-E8DB84A1B2C3",state="calibrating"} 0
+shellycover_state{cover="0",device="shellyswitch25-E8DB84A1B2C3",state="closed"} 0
+shellycover_state{cover="0",device="shellyswitch25-E8DB84A1B2C3",state="closing"} 0
+shellycover_state{cover="0",device="shellyswitch25-E8DB84A1B2C3",state="open"} 0
+shellycover_state{cover="0",device="shellyswitch25-E8DB84A1B2C3",state="opening"} 0
+shellycover_state{cover="0",device="shellyswitch25-E8DB84A1B2C3",state="stopped"} 0
+shellycover_state{cover="0",device="shellyswitch25-E8DB84A1B2C3",state="unknown"} 1
+shellycover_state{cover="1",device="shellyprodualcovpm-a8032ab67890",state="calibrating"} 0
+shellycover_state{cover="1",device="shellyprodualcovpm-a8032ab67890",state="closed"} 0
+shellycover_state{cover="1",device="shellyprodualcovpm-a8032ab67890",state="closing"} 0
+shellycover_state{cover="1",device="shellyprodualcovpm-a8032ab67890",state="open"} 1
+shellycover_state{cover="1",device="shellyprodualcovpm-a8032ab67890",state="opening"} 0
+shellycover_state{cover="1",device="shellyprodualcovpm-a8032ab67890",state="stopped"} 0
+shellycover_state{cover="1",device="shellyprodualcovpm-a8032ab67890",state="unknown"} 0
+# HELP shellycover_target_position target position in percent while the cover moves
+# TYPE shellycover_target_position gauge
+shellycover_target_position{cover="0",device="shellyplus2pm-a8032ab12345"} 0
+# HELP shellycover_temperature internal device temperature
+# TYPE shellycover_temperature gauge
+shellycover_temperature{cover="0",device="shellyplus2pm-a8032ab12345",unit="c"} 36.9
+shellycover_temperature{cover="0",device="shellyplus2pm-a8032ab12345",unit="f"} 98.5
+# HELP shellycover_up 1 if the last reading of the device was fine
+# TYPE shellycover_up gauge
+shellycover_up{device="shellyplus2pm-a8032ab12345"} 1
+shellycover_up{device="shellyplus2pm-b0b21c123456"} 0
+shellycover_up{device="shellyprodualcovpm-a8032ab67890"} 1
+shellycover_up{device="shellyswitch25-98CDAC1F2A3B"} 1
+shellycover_up{device="shellyswitch25-C45BBE6FDA5D"} 0
+shellycover_up{device="shellyswitch25-E8DB84A1B2C3"} 0
+# HELP shellycover_voltage supply voltage in Volts
+# TYPE shellycover_voltage gauge
+shellycover_voltage{cover="0",device="shellyplus2pm-a8032ab12345"} 231.2
+`),
+		"shellycover_current",
+		"shellycover_energy_total",
+		"shellycover_position",
+		"shellycover_power",
+		"shellycover_scrape_errors_total",
+		"shellycover_state",
+		"shellycover_target_position",
+		"shellycover_temperature",
+		"shellycover_up",
+		"shellycover_voltage",
+	)
+	require.NoError(t, err)
+}
@@ -0,0 +1,253 @@
+package devicemap
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync/atomic"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"gopkg.in/yaml.v3"
+)
+
+// A device mapping file assigns friendly labels to devices:
+//
+//	devices:
+//	  - id: shellyem3-washtumbler
+//	    name: Washing machine
+//	    room: Cellar
+//	    floor: basement
+//	  - mac: A8032AB12345
+//	    name: Living room
+//	    labels:
+//	      circuit: "3"
+//
+// The collectors label devices differently, the MAC for ht, the src for Gen2
+// and a shortened ID for threeem. A device label matches an entry if it is
+// the id, the id without its model prefix (shellyem3-), the mac or ends with
+// -<mac> like Gen2 IDs do. MACs are compared case-insensitive without colons.
+
+// deviceLabels are the labels of the collectors holding a device ID.
+var deviceLabels = []string{"device", "gateway"}
+
+var labelNameRE = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// Device is an entry of the mapping file.
+type Device struct {
+	ID     string            `yaml:"id"`
+	MAC    string            `yaml:"mac"`
+	Name   string            `yaml:"name"`
+	Room   string            `yaml:"room"`
+	Floor  string            `yaml:"floor"`
+	Labels map[string]string `yaml:"labels"`
+}
+
+// labels returns the additional label pairs sorted by name.
+func (d Device) labels() []*dto.LabelPair {
+	var lps []*dto.LabelPair
+	add := func(name, value string) {
+		if value != "" {
+			lps = append(lps, &dto.LabelPair{Name: &name, Value: &value})
+		}
+	}
+	add("name", d.Name)
+	add("room", d.Room)
+	add("floor", d.Floor)
+	for name, value := range d.Labels {
+		add(name, value)
+	}
+	sort.Slice(lps, func(i, j int) bool {
+		return lps[i].GetName() < lps[j].GetName()
+	})
+	return lps
+}
+
+// Map looks up the entry of a device label.
+type Map struct {
+	ids      map[string]*Device
+	shortIDs map[string]*Device
+	macs     map[string]*Device
+}
+
+func normalizeMAC(mac string) string {
+	return strings.ToUpper(strings.ReplaceAll(mac, ":", ""))
+}
+
+// Parse decodes and validates a mapping file.
+func Parse(data []byte) (*Map, error) {
+	devices, err := decode(data)
+	if err != nil {
+		return nil, err
+	}
+	return New(devices)
+}
+
+func decode(data []byte) ([]Device, error) {
+	var file struct {
+		Devices []Device `yaml:"devices"`
+	}
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&file); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("devicemap: %w", err)
+	}
+	return file.Devices, nil
+}
+
+// New validates the devices and creates the Map.
+func New(devices []Device) (*Map, error) {
+	m := &Map{
+		ids:      make(map[string]*Device),
+		shortIDs: make(map[string]*Device),
+		macs:     make(map[string]*Device),
+	}
+	for i := range devices {
+		d := &devices[i]
+		if d.ID == "" && d.MAC == "" {
+			return nil, fmt.Errorf("devicemap: device %d: id or mac required", i)
+		}
+		for name := range d.Labels {
+			switch {
+			case !labelNameRE.MatchString(name):
+				return nil, fmt.Errorf("devicemap: device %d: invalid label name %q", i, name)
+			case name == "name" || name == "room" || name == "floor" || strings.HasPrefix(name, "__"):
+				return nil, fmt.Errorf("devicemap: device %d: reserved label name %q", i, name)
+			}
+			for _, l := range deviceLabels {
+				if name == l {
+					return nil, fmt.Errorf("devicemap: device %d: reserved label name %q", i, name)
+				}
+			}
+		}
+		if d.ID != "" {
+			if _, ok := m.ids[d.ID]; ok {
+				return nil, fmt.Errorf("devicemap: device %d: duplicate id %q", i, d.ID)
+			}
+			m.ids[d.ID] = d
+			// model prefixes can have a hyphen too, e.g. shellyplug-s-
+			if j := strings.LastIndexByte(d.ID, '-'); j >= 0 {
+				short := d.ID[j+1:]
+				if _, ok := m.shortIDs[short]; ok {
+					return nil, fmt.Errorf("devicemap: device %d: duplicate short id %q", i, short)
+				}
+				m.shortIDs[short] = d
+			}
+		}
+		if d.MAC != "" {
+			mac := normalizeMAC(d.MAC)
+			if _, ok := m.macs[mac]; ok {
+				return nil, fmt.Errorf("devicemap: device %d: duplicate mac %q", i, d.MAC)
+			}
+			m.macs[mac] = d
+		}
+	}
+	return m, nil
+}
+
+// Load reads and parses the mapping file.
+func Load(path string) (*Map, error) {
+	devices, err := ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return New(devices)
+}
+
+// ReadFile returns the entries of the mapping file without validating them,
+// e.g. to merge them with other entries before calling New.
+func ReadFile(path string) ([]Device, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("devicemap: %w", err)
+	}
+	return decode(data)
+}
+
+// Lookup returns the entry of the device label. It is safe to call on a nil
+// Map.
+func (m *Map) Lookup(device string) (Device, bool) {
+	if m == nil || device == "" {
+		return Device{}, false
+	}
+	if d, ok := m.ids[device]; ok {
+		return *d, true
+	}
+	if d, ok := m.macs[normalizeMAC(device)]; ok {
+		return *d, true
+	}
+	if i := strings.LastIndexByte(device, '-'); i >= 0 {
+		if d, ok := m.macs[normalizeMAC(device[i+1:])]; ok {
+			return *d, true
+		}
+	}
+	if d, ok := m.shortIDs[device]; ok {
+		return *d, true
+	}
+	return Device{}, false
+}
+
+// Labeler adds the labels of the current Map to gathered metrics. The Map can
+// be swapped at any time, e.g. after the file changed.
+type Labeler struct {
+	m atomic.Pointer[Map]
+}
+
+// Set replaces the Map, nil disables the labels.
+func (l *Labeler) Set(m *Map) {
+	l.m.Store(m)
+}
+
+// Gatherer wraps g and adds the device labels to every metric with a device
+// label found in the Map. Labels the metric already has are kept.
+func (l *Labeler) Gatherer(g prometheus.Gatherer) prometheus.Gatherer {
+	return prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) {
+		mfs, err := g.Gather()
+		m := l.m.Load()
+		if m == nil {
+			return mfs, err
+		}
+		for _, mf := range mfs {
+			for _, metric := range mf.Metric {
+				relabel(m, metric)
+			}
+		}
+		return mfs, err
+	})
+}
+
+func relabel(m *Map, metric *dto.Metric) {
+	var (
+		d     Device
+		found bool
+	)
+	existing := make(map[string]bool, len(metric.Label))
+	for _, lp := range metric.Label {
+		existing[lp.GetName()] = true
+		for _, l := range deviceLabels {
+			if !found && lp.GetName() == l {
+				d, found = m.Lookup(lp.GetValue())
+			}
+		}
+	}
+	if !found {
+		return
+	}
+	added := false
+	for _, lp := range d.labels() {
+		if !existing[lp.GetName()] {
+			metric.Label = append(metric.Label, lp)
+			added = true
+		}
+	}
+	if added {
+		sort.Slice(metric.Label, func(i, j int) bool {
+			return metric.Label[i].GetName() < metric.Label[j].GetName()
+		})
+	}
+}
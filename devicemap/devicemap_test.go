@@ -0,0 +1,84 @@
+package devicemap
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMap_Lookup(t *testing.T) {
+	m, err := Load("testdata/devices.yaml")
+	require.NoError(t, err)
+
+	tests := []struct {
+		device, name string
+	}{
+		{"shellyem3-washtumbler", "Washing machine"},
+		{"washtumbler", "Washing machine"},            // threeem
+		{"shellyplus1pm-a8032ab12345", "Living room"}, // Gen2 src
+		{"A8032AB12345", "Living room"},               // MAC
+		{"485519ABCDEF", "Bedroom H&T"},               // ht
+		{"shellyht-485519abcdef", "Bedroom H&T"},      // Gen1 ID
+		{"desklamp", "Desk lamp"},                     // model with a hyphen
+		{"shellyplus1pm-000000000000", ""},
+	}
+	for _, tt := range tests {
+		d, ok := m.Lookup(tt.device)
+		assert.Equal(t, tt.name != "", ok, tt.device)
+		assert.Equal(t, tt.name, d.Name, tt.device)
+	}
+
+	var nilMap *Map
+	_, ok := nilMap.Lookup("washtumbler")
+	assert.False(t, ok)
+}
+
+func TestParse_invalid(t *testing.T) {
+	tests := map[string]string{
+		"missing id":         "devices:\n  - name: x\n",
+		"unknown field":      "devices:\n  - id: a\n    nmae: x\n",
+		"reserved label":     "devices:\n  - id: a\n    labels:\n      device: x\n",
+		"invalid label":      "devices:\n  - id: a\n    labels:\n      my-label: x\n",
+		"duplicate id":       "devices:\n  - id: a\n  - id: a\n",
+		"duplicate mac":      "devices:\n  - mac: aa:bb\n  - mac: AABB\n",
+		"duplicate short id": "devices:\n  - id: shellyem3-a\n  - id: shellyplug-s-a\n",
+	}
+	for name, data := range tests {
+		_, err := Parse([]byte(data))
+		assert.Error(t, err, name)
+	}
+}
+
+func TestLabeler_Gatherer(t *testing.T) {
+	reg := prometheus.NewPedanticRegistry()
+	g := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "shelly3em_power", Help: "power"}, []string{"device", "phase"})
+	g.WithLabelValues("washtumbler", "0").Set(5)
+	g.WithLabelValues("other", "0").Set(7)
+	reg.MustRegister(g)
+
+	m, err := Load("testdata/devices.yaml")
+	require.NoError(t, err)
+	var l Labeler
+	l.Set(m)
+
+	err = testutil.GatherAndCompare(l.Gatherer(reg), strings.NewReader(`
+# HELP shelly3em_power power
+# TYPE shelly3em_power gauge
+shelly3em_power{device="other",phase="0"} 7
+shelly3em_power{device="washtumbler",floor="basement",name="Washing machine",phase="0",room="Cellar"} 5
+`))
+	require.NoError(t, err)
+
+	l.Set(nil)
+	err = testutil.GatherAndCompare(l.Gatherer(reg), strings.NewReader(`
+# HELP shelly3em_power power
+# TYPE shelly3em_power gauge
+shelly3em_power{device="other",phase="0"} 7
+shelly3em_power{device="washtumbler",phase="0"} 5
+`))
+	require.NoError(t, err)
+}
@@ -0,0 +1,202 @@
+package gas
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/SchumacherFM/prometheus_shelly_exporter/collector"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+// Shelly Gas publishes each value on its own topic:
+//
+//	shellies/shellygas-<id>/sensor/operation      unknown|warmup|normal|fault
+//	shellies/shellygas-<id>/sensor/gas            unknown|none|mild|heavy|test
+//	shellies/shellygas-<id>/sensor/self_test      not_completed|completed|running|pending
+//	shellies/shellygas-<id>/sensor/concentration  0
+//
+// The string values are exported as enum gauges: one series per known state
+// and the current one set to 1. Any other value counts as a parse error and
+// sets the state unknown.
+var (
+	operationStates = []string{"unknown", "warmup", "normal", "fault"}
+	alarmStates     = []string{"unknown", "none", "mild", "heavy", "test"}
+	selfTestStates  = []string{"unknown", "not_completed", "completed", "running", "pending"}
+)
+
+type device struct {
+	operation        string
+	alarm            string
+	selfTest         string
+	concentration    float64
+	hasConcentration bool
+}
+
+type Collector struct {
+	opts              Options
+	concentrationDesc *prometheus.Desc
+	alarmDesc         *prometheus.Desc
+	operationDesc     *prometheus.Desc
+	selfTestDesc      *prometheus.Desc
+	health            *collector.Health
+
+	mu      sync.Mutex
+	devices map[string]*device // device ID => state
+}
+
+type Options struct {
+	collector.Options
+	TestCB func()
+}
+
+func init() {
+	collector.Register(collector.Definition{
+		Name:          "gas",
+		Help:          "Shelly Gas",
+		Default:       true,
+		Subscriptions: []string{"shellies/+/sensor/#"},
+		New: func(ctx context.Context, messageChan <-chan mqtt.Message, opts collector.Options) prometheus.Collector {
+			return NewCollector(ctx, messageChan, Options{Options: opts})
+		},
+	})
+}
+
+func NewCollector(ctx context.Context, messageChan <-chan mqtt.Message, opts Options) *Collector {
+	c := &Collector{
+		opts:              opts,
+		concentrationDesc: prometheus.NewDesc("shellygas_concentration_ppm", "gas concentration in ppm", []string{"device"}, nil),
+		alarmDesc:         prometheus.NewDesc("shellygas_alarm_state", "gas alarm level, 1 for the current state", []string{"device", "state"}, nil),
+		operationDesc:     prometheus.NewDesc("shellygas_operation", "operation state of the sensor, 1 for the current state", []string{"device", "state"}, nil),
+		selfTestDesc:      prometheus.NewDesc("shellygas_self_test", "self-test state of the sensor, 1 for the current state", []string{"device", "state"}, nil),
+		health:            collector.NewHealth("shellygas", opts.Options),
+		devices:           make(map[string]*device),
+	}
+
+	go func() {
+		for {
+			select {
+			case msg, ok := <-messageChan:
+				if !ok {
+					if opts.TestCB != nil {
+						opts.TestCB()
+					}
+					return
+				}
+				c.handle(msg.Topic(), msg.Payload())
+
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return c
+}
+
+func (c *Collector) handle(topic string, payload []byte) {
+	// shellies/shellygas-<id>/sensor/concentration
+	topicPaths := strings.Split(topic, "/")
+	if len(topicPaths) != 4 {
+		return
+	}
+	devID, value := topicPaths[1], strings.TrimSpace(string(payload))
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	d, ok := c.devices[devID]
+	if !ok {
+		d = &device{}
+	}
+
+	var err error
+	switch topicPaths[3] {
+	case "operation":
+		d.operation, err = enumState(value, operationStates)
+	case "gas":
+		d.alarm, err = enumState(value, alarmStates)
+	case "self_test":
+		d.selfTest, err = enumState(value, selfTestStates)
+	case "concentration":
+		f64, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			c.health.ParseError(devID, topic, err)
+			return
+		}
+		d.concentration, d.hasConcentration = f64, true
+	default:
+		return
+	}
+	// only a device which sent one of the gas topics gets tracked, an H&T
+	// also publishes below sensor/.
+	c.devices[devID] = d
+	if err != nil {
+		c.health.ParseError(devID, topic, err)
+		return
+	}
+	c.opts.Timestamps.Observe(devID, 0)
+	c.health.OK(devID)
+
+	c.opts.Log.Debug("message from mqtt",
+		zap.String("topic", topic),
+		zap.Int("length", len(payload)))
+}
+
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.concentrationDesc
+	ch <- c.alarmDesc
+	ch <- c.operationDesc
+	ch <- c.selfTestDesc
+	c.health.Describe(ch)
+}
+
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	c.collect(ch)
+	c.health.Collect(ch)
+}
+
+// enumState returns the value if it is one of the states, otherwise unknown
+// and an error.
+func enumState(value string, states []string) (string, error) {
+	if !slices.Contains(states, value) {
+		return "unknown", fmt.Errorf("gas: unknown state %q", value)
+	}
+	return value, nil
+}
+
+// collectEnum sends one series per known state.
+func collectEnum(ch chan<- prometheus.Metric, desc *prometheus.Desc, devID, current string, states []string) {
+	if current == "" {
+		return
+	}
+	for _, state := range states {
+		var v float64
+		if state == current {
+			v = 1
+		}
+		ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, v, devID, state)
+	}
+}
+
+func (c *Collector) collect(ch chan<- prometheus.Metric) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for devID, d := range c.devices {
+		if c.opts.Presence.Offline(devID) {
+			continue
+		}
+		if d.hasConcentration {
+			ch <- prometheus.MustNewConstMetric(c.concentrationDesc, prometheus.GaugeValue, d.concentration, devID)
+		}
+		collectEnum(ch, c.alarmDesc, devID, d.alarm, alarmStates)
+		collectEnum(ch, c.operationDesc, devID, d.operation, operationStates)
+		collectEnum(ch, c.selfTestDesc, devID, d.selfTest, selfTestStates)
+	}
+}
@@ -0,0 +1,210 @@
+package gas
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/SchumacherFM/prometheus_shelly_exporter/collector"
+	"github.com/SchumacherFM/prometheus_shelly_exporter/collector/collectortest"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+var _ prometheus.Collector = (*Collector)(nil)
+
+func TestCollector_collect(t *testing.T) {
+	tests := []struct {
+		name     string
+		messages []string // topic payload
+		want     string
+	}{
+		{
+			name: "normal operation",
+			messages: []string{
+				"shellies/shellygas-C45BBE6FDA5D/sensor/operation normal",
+				"shellies/shellygas-C45BBE6FDA5D/sensor/gas none",
+				"shellies/shellygas-C45BBE6FDA5D/sensor/self_test completed",
+				"shellies/shellygas-C45BBE6FDA5D/sensor/concentration 0",
+			},
+			want: `
+# HELP shellygas_alarm_state gas alarm level, 1 for the current state
+# TYPE shellygas_alarm_state gauge
+shellygas_alarm_state{device="shellygas-C45BBE6FDA5D",state="heavy"} 0
+shellygas_alarm_state{device="shellygas-C45BBE6FDA5D",state="mild"} 0
+shellygas_alarm_state{device="shellygas-C45BBE6FDA5D",state="none"} 1
+shellygas_alarm_state{device="shellygas-C45BBE6FDA5D",state="test"} 0
+shellygas_alarm_state{device="shellygas-C45BBE6FDA5D",state="unknown"} 0
+# HELP shellygas_concentration_ppm gas concentration in ppm
+# TYPE shellygas_concentration_ppm gauge
+shellygas_concentration_ppm{device="shellygas-C45BBE6FDA5D"} 0
+# HELP shellygas_operation operation state of the sensor, 1 for the current state
+# TYPE shellygas_operation gauge
+shellygas_operation{device="shellygas-C45BBE6FDA5D",state="fault"} 0
+shellygas_operation{device="shellygas-C45BBE6FDA5D",state="normal"} 1
+shellygas_operation{device="shellygas-C45BBE6FDA5D",state="unknown"} 0
+shellygas_operation{device="shellygas-C45BBE6FDA5D",state="warmup"} 0
+# HELP shellygas_scrape_errors_total readings of the device which failed
+# TYPE shellygas_scrape_errors_total counter
+shellygas_scrape_errors_total{device="shellygas-C45BBE6FDA5D",reason="invalid_reading"} 0
+shellygas_scrape_errors_total{device="shellygas-C45BBE6FDA5D",reason="parse_error"} 0
+shellygas_scrape_errors_total{device="shellygas-C45BBE6FDA5D",reason="stale"} 0
+# HELP shellygas_self_test self-test state of the sensor, 1 for the current state
+# TYPE shellygas_self_test gauge
+shellygas_self_test{device="shellygas-C45BBE6FDA5D",state="completed"} 1
+shellygas_self_test{device="shellygas-C45BBE6FDA5D",state="not_completed"} 0
+shellygas_self_test{device="shellygas-C45BBE6FDA5D",state="pending"} 0
+shellygas_self_test{device="shellygas-C45BBE6FDA5D",state="running"} 0
+shellygas_self_test{device="shellygas-C45BBE6FDA5D",state="unknown"} 0
+# HELP shellygas_up 1 if the last reading of the device was fine
+# TYPE shellygas_up gauge
+shellygas_up{device="shellygas-C45BBE6FDA5D"} 1
+`,
+		},
+		{
+			name: "heavy alarm",
+			messages: []string{
+				"shellies/shellygas-C45BBE6FDA5D/sensor/gas none",
+				"shellies/shellygas-C45BBE6FDA5D/sensor/concentration 120",
+				"shellies/shellygas-C45BBE6FDA5D/sensor/gas heavy",
+				"shellies/shellygas-C45BBE6FDA5D/sensor/concentration 1350",
+			},
+			want: `
+# HELP shellygas_alarm_state gas alarm level, 1 for the current state
+# TYPE shellygas_alarm_state gauge
+shellygas_alarm_state{device="shellygas-C45BBE6FDA5D",state="heavy"} 1
+shellygas_alarm_state{device="shellygas-C45BBE6FDA5D",state="mild"} 0
+shellygas_alarm_state{device="shellygas-C45BBE6FDA5D",state="none"} 0
+shellygas_alarm_state{device="shellygas-C45BBE6FDA5D",state="test"} 0
+shellygas_alarm_state{device="shellygas-C45BBE6FDA5D",state="unknown"} 0
+# HELP shellygas_concentration_ppm gas concentration in ppm
+# TYPE shellygas_concentration_ppm gauge
+shellygas_concentration_ppm{device="shellygas-C45BBE6FDA5D"} 1350
+# HELP shellygas_scrape_errors_total readings of the device which failed
+# TYPE shellygas_scrape_errors_total counter
+shellygas_scrape_errors_total{device="shellygas-C45BBE6FDA5D",reason="invalid_reading"} 0
+shellygas_scrape_errors_total{device="shellygas-C45BBE6FDA5D",reason="parse_error"} 0
+shellygas_scrape_errors_total{device="shellygas-C45BBE6FDA5D",reason="stale"} 0
+# HELP shellygas_up 1 if the last reading of the device was fine
+# TYPE shellygas_up gauge
+shellygas_up{device="shellygas-C45BBE6FDA5D"} 1
+`,
+		},
+		{
+			name: "warmup with a running self-test",
+			messages: []string{
+				"shellies/shellygas-C45BBE6FDA5D/sensor/operation warmup",
+				"shellies/shellygas-C45BBE6FDA5D/sensor/self_test running",
+			},
+			want: `
+# HELP shellygas_operation operation state of the sensor, 1 for the current state
+# TYPE shellygas_operation gauge
+shellygas_operation{device="shellygas-C45BBE6FDA5D",state="fault"} 0
+shellygas_operation{device="shellygas-C45BBE6FDA5D",state="normal"} 0
+shellygas_operation{device="shellygas-C45BBE6FDA5D",state="unknown"} 0
+shellygas_operation{device="shellygas-C45BBE6FDA5D",state="warmup"} 1
+# HELP shellygas_scrape_errors_total readings of the device which failed
+# TYPE shellygas_scrape_errors_total counter
+shellygas_scrape_errors_total{device="shellygas-C45BBE6FDA5D",reason="invalid_reading"} 0
+shellygas_scrape_errors_total{device="shellygas-C45BBE6FDA5D",reason="parse_error"} 0
+shellygas_scrape_errors_total{device="shellygas-C45BBE6FDA5D",reason="stale"} 0
+# HELP shellygas_self_test self-test state of the sensor, 1 for the current state
+# TYPE shellygas_self_test gauge
+shellygas_self_test{device="shellygas-C45BBE6FDA5D",state="completed"} 0
+shellygas_self_test{device="shellygas-C45BBE6FDA5D",state="not_completed"} 0
+shellygas_self_test{device="shellygas-C45BBE6FDA5D",state="pending"} 0
+shellygas_self_test{device="shellygas-C45BBE6FDA5D",state="running"} 1
+shellygas_self_test{device="shellygas-C45BBE6FDA5D",state="unknown"} 0
+# HELP shellygas_up 1 if the last reading of the device was fine
+# TYPE shellygas_up gauge
+shellygas_up{device="shellygas-C45BBE6FDA5D"} 1
+`,
+		},
+		{
+			name: "invalid concentration",
+			messages: []string{
+				"shellies/shellygas-C45BBE6FDA5D/sensor/concentration 0",
+				"shellies/shellygas-C45BBE6FDA5D/sensor/concentration n/a",
+			},
+			want: `
+# HELP shellygas_concentration_ppm gas concentration in ppm
+# TYPE shellygas_concentration_ppm gauge
+shellygas_concentration_ppm{device="shellygas-C45BBE6FDA5D"} 0
+# HELP shellygas_scrape_errors_total readings of the device which failed
+# TYPE shellygas_scrape_errors_total counter
+shellygas_scrape_errors_total{device="shellygas-C45BBE6FDA5D",reason="invalid_reading"} 0
+shellygas_scrape_errors_total{device="shellygas-C45BBE6FDA5D",reason="parse_error"} 1
+shellygas_scrape_errors_total{device="shellygas-C45BBE6FDA5D",reason="stale"} 0
+# HELP shellygas_up 1 if the last reading of the device was fine
+# TYPE shellygas_up gauge
+shellygas_up{device="shellygas-C45BBE6FDA5D"} 0
+`,
+		},
+		{
+			name: "unknown state",
+			messages: []string{
+				"shellies/shellygas-C45BBE6FDA5D/sensor/operation normal",
+				"shellies/shellygas-C45BBE6FDA5D/sensor/operation sleeping",
+			},
+			want: `
+# HELP shellygas_operation operation state of the sensor, 1 for the current state
+# TYPE shellygas_operation gauge
+shellygas_operation{device="shellygas-C45BBE6FDA5D",state="fault"} 0
+shellygas_operation{device="shellygas-C45BBE6FDA5D",state="normal"} 0
+shellygas_operation{device="shellygas-C45BBE6FDA5D",state="unknown"} 1
+shellygas_operation{device="shellygas-C45BBE6FDA5D",state="warmup"} 0
+# HELP shellygas_scrape_errors_total readings of the device which failed
+# TYPE shellygas_scrape_errors_total counter
+shellygas_scrape_errors_total{device="shellygas-C45BBE6FDA5D",reason="invalid_reading"} 0
+shellygas_scrape_errors_total{device="shellygas-C45BBE6FDA5D",reason="parse_error"} 1
+shellygas_scrape_errors_total{device="shellygas-C45BBE6FDA5D",reason="stale"} 0
+# HELP shellygas_up 1 if the last reading of the device was fine
+# TYPE shellygas_up gauge
+shellygas_up{device="shellygas-C45BBE6FDA5D"} 0
+`,
+		},
+		{
+			name: "H&T below sensor",
+			messages: []string{
+				"shellies/shellyht-6FDA5D/sensor/temperature 21.5",
+				"shellies/shellyht-6FDA5D/sensor/humidity 48",
+			},
+			want: "",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := collect(t, tt.messages)
+			err := testutil.CollectAndCompare(c, strings.NewReader(tt.want),
+				"shellygas_alarm_state",
+				"shellygas_concentration_ppm",
+				"shellygas_operation",
+				"shellygas_self_test",
+				"shellygas_scrape_errors_total",
+				"shellygas_up",
+			)
+			require.NoError(t, err)
+		})
+	}
+}
+
+// collect feeds the messages to a new collector and waits until it read all
+// of them.
+func collect(t *testing.T, messages []string) *Collector {
+	t.Helper()
+	msgChan := make(chan mqtt.Message)
+	msgGoRoutineDone := make(chan struct{})
+	c := NewCollector(context.Background(), msgChan, Options{
+		Options: collector.Options{Log: zap.NewNop()},
+		TestCB: func() {
+			close(msgGoRoutineDone)
+		},
+	})
+	collectortest.Send(msgChan, messages...)
+	close(msgChan)
+	<-msgGoRoutineDone
+	return c
+}
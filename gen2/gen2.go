@@ -0,0 +1,223 @@
+package gen2
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/SchumacherFM/prometheus_shelly_exporter/collector"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/tidwall/gjson"
+	"go.uber.org/zap"
+)
+
+// Gen2 and Gen3 devices describe their state as a set of components in
+// NotifyFullStatus and NotifyStatus, the key is <type>:<id> or just <type>
+// for singletons like sys or wifi:
+//
+//	{"src":"shellyplus1pm-a8032ab12345","method":"NotifyStatus","params":{"ts":1707640852.74,
+//	  "switch:0":{"id":0,"apower":8.9,"voltage":230.1}}}
+//
+// The collector keeps the merged status per component and hands it to the
+// Mapper registered for the type, so the device model doesn't matter.
+
+// Mapper turns the status of one component into metrics.
+type Mapper interface {
+	// Describe sends all descriptors the mapper may use.
+	Describe(ch chan<- *prometheus.Desc)
+	// Collect sends the metrics of component id of the device. status is the
+	// merged JSON object of the component.
+	Collect(ch chan<- prometheus.Metric, device, id string, status gjson.Result)
+}
+
+var (
+	mappersMu sync.RWMutex
+	mappers   = map[string]Mapper{} // component type => mapper
+)
+
+// Register adds the Mapper for a component type and replaces an existing one.
+// Call it before the collector gets registered with prometheus.
+func Register(typ string, m Mapper) {
+	mappersMu.Lock()
+	defer mappersMu.Unlock()
+	mappers[typ] = m
+}
+
+func mapper(typ string) (Mapper, bool) {
+	mappersMu.RLock()
+	defer mappersMu.RUnlock()
+	m, ok := mappers[typ]
+	return m, ok
+}
+
+// component is the merged status, NotifyStatus only carries changed fields.
+type component struct {
+	typ    string
+	id     string
+	fields map[string]string // raw top level key, quoted => raw JSON value
+}
+
+func (c *component) status() gjson.Result {
+	keys := make([]string, 0, len(c.fields))
+	for k := range c.fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var buf strings.Builder
+	buf.WriteByte('{')
+	for i, k := range keys {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		buf.WriteString(k)
+		buf.WriteByte(':')
+		buf.WriteString(c.fields[k])
+	}
+	buf.WriteByte('}')
+	return gjson.Parse(buf.String())
+}
+
+type Collector struct {
+	opts   Options
+	health *collector.Health
+
+	mu      sync.Mutex
+	devices map[string]map[string]*component // device ID => component key => status
+	unknown map[string]bool                  // component types already logged
+}
+
+type Options struct {
+	collector.Options
+	TestCB func()
+}
+
+func init() {
+	collector.Register(collector.Definition{
+		Name:          "gen2",
+		Help:          "any Gen2/Gen3 device, all known components",
+		Default:       false, // overlaps with the dedicated collectors, e.g. cover and input
+		Subscriptions: []string{"+/events/rpc"},
+		New: func(ctx context.Context, messageChan <-chan mqtt.Message, opts collector.Options) prometheus.Collector {
+			return NewCollector(ctx, messageChan, Options{Options: opts})
+		},
+	})
+}
+
+func NewCollector(ctx context.Context, messageChan <-chan mqtt.Message, opts Options) *Collector {
+	c := &Collector{
+		opts:    opts,
+		health:  collector.NewHealth("shellygen2", opts.Options),
+		devices: make(map[string]map[string]*component),
+		unknown: make(map[string]bool),
+	}
+
+	go func() {
+		for {
+			select {
+			case msg, ok := <-messageChan:
+				if !ok {
+					if opts.TestCB != nil {
+						opts.TestCB()
+					}
+					return
+				}
+				if false == strings.HasSuffix(msg.Topic(), "/rpc") {
+					continue
+				}
+
+				c.handle(msg.Topic(), msg.Payload())
+
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return c
+}
+
+func (c *Collector) handle(topic string, payload []byte) {
+	r := gjson.ParseBytes(payload)
+	method := r.Get("method").String()
+	if method != "NotifyStatus" && method != "NotifyFullStatus" {
+		return
+	}
+	devID := r.Get("src").String()
+	if devID == "" {
+		return
+	}
+
+	c.opts.Log.Debug("message from mqtt",
+		zap.String("topic", topic),
+		zap.Int("length", len(payload)))
+	c.opts.Timestamps.Observe(devID, r.Get("params.ts").Float())
+	c.health.OK(devID)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	comps, ok := c.devices[devID]
+	if !ok || method == "NotifyFullStatus" {
+		// a full status drops components which got removed, e.g. an add-on
+		comps = make(map[string]*component)
+		c.devices[devID] = comps
+	}
+
+	r.Get("params").ForEach(func(key, value gjson.Result) bool {
+		if !value.IsObject() {
+			return true // ts
+		}
+		typ, id, _ := strings.Cut(key.String(), ":")
+		if _, ok := mapper(typ); !ok {
+			if !c.unknown[typ] {
+				c.unknown[typ] = true
+				c.opts.Log.Info("unknown component type", zap.String("type", typ), zap.String("device", devID))
+			}
+			return true
+		}
+
+		comp, ok := comps[key.String()]
+		if !ok {
+			comp = &component{typ: typ, id: id, fields: make(map[string]string)}
+			comps[key.String()] = comp
+		}
+		value.ForEach(func(field, v gjson.Result) bool {
+			comp.fields[field.Raw] = v.Raw
+			return true
+		})
+		return true
+	})
+}
+
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	mappersMu.RLock()
+	for _, m := range mappers {
+		m.Describe(ch)
+	}
+	mappersMu.RUnlock()
+	c.health.Describe(ch)
+}
+
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	c.collect(ch)
+	c.health.Collect(ch)
+}
+
+func (c *Collector) collect(ch chan<- prometheus.Metric) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for devID, comps := range c.devices {
+		if c.opts.Presence.Offline(devID) {
+			continue
+		}
+		for _, comp := range comps {
+			if m, ok := mapper(comp.typ); ok {
+				m.Collect(ch, devID, comp.id, comp.status())
+			}
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package gen2
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/SchumacherFM/prometheus_shelly_exporter/collector"
+	"github.com/SchumacherFM/prometheus_shelly_exporter/collector/collectortest"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+var _ prometheus.Collector = (*Collector)(nil)
+
+func TestCollector_collect(t *testing.T) {
+	ctx := context.Background()
+	msgChan := make(chan mqtt.Message)
+
+	log, _ := zap.NewDevelopment(zap.Development())
+	msgGoRoutineDone := make(chan struct{})
+	c := NewCollector(ctx, msgChan, Options{
+		Options: collector.Options{Log: log},
+		TestCB: func() {
+			close(msgGoRoutineDone)
+		},
+	})
+
+	collectortest.SendFile(t, msgChan, "testdata/gen2.txt")
+	close(msgChan)
+	<-msgGoRoutineDone
+
+	err := testutil.CollectAndCompare(c, strings.NewReader(`
+# HELP shellygen2_devicepower_battery_percent battery level in percent
+# TYPE shellygen2_devicepower_battery_percent gauge
+shellygen2_devicepower_battery_percent{device="shellyhtg3-e4b063d4a1b2",id="0"} 96
+# HELP shellygen2_humidity_percent relative humidity in percent
+# TYPE shellygen2_humidity_percent gauge
+shellygen2_humidity_percent{device="shellyhtg3-e4b063d4a1b2",id="0"} 48.5
+# HELP shellygen2_input_state state of the input, 1 if closed
+# TYPE shellygen2_input_state gauge
+shellygen2_input_state{device="shellyplus1pm-a8032ab12345",id="0"} 0
+# HELP shellygen2_switch_current current in Amps
+# TYPE shellygen2_switch_current gauge
+shellygen2_switch_current{device="shellyplus1pm-a8032ab12345",id="0"} 0.08
+# HELP shellygen2_switch_energy_total total active energy in Wh
+# TYPE shellygen2_switch_energy_total counter
+shellygen2_switch_energy_total{device="shellyplus1pm-a8032ab12345",id="0"} 1020.5
+# HELP shellygen2_switch_output 1 if the output is on
+# TYPE shellygen2_switch_output gauge
+shellygen2_switch_output{device="shellyplus1pm-a8032ab12345",id="0"} 1
+# HELP shellygen2_switch_power instantaneous active power in Watts
+# TYPE shellygen2_switch_power gauge
+shellygen2_switch_power{device="shellyplus1pm-a8032ab12345",id="0"} 12.4
+# HELP shellygen2_switch_voltage voltage in Volts
+# TYPE shellygen2_switch_voltage gauge
+shellygen2_switch_voltage{device="shellyplus1pm-a8032ab12345",id="0"} 230.1
+# HELP shellygen2_sys_uptime_seconds seconds since the last boot
+# TYPE shellygen2_sys_uptime_seconds gauge
+shellygen2_sys_uptime_seconds{device="shellyplus1pm-a8032ab12345",id=""} 3600
+# HELP shellygen2_temperature_celsius temperature in °C
+# TYPE shellygen2_temperature_celsius gauge
+shellygen2_temperature_celsius{device="shellyhtg3-e4b063d4a1b2",id="0"} 21.3
+# HELP shellygen2_wifi_rssi signal strength in dBm
+# TYPE shellygen2_wifi_rssi gauge
+shellygen2_wifi_rssi{device="shellyplus1pm-a8032ab12345",id=""} -58
+`),
+		"shellygen2_devicepower_battery_percent",
+		"shellygen2_humidity_percent",
+		"shellygen2_input_state",
+		"shellygen2_switch_current",
+		"shellygen2_switch_energy_total",
+		"shellygen2_switch_output",
+		"shellygen2_switch_power",
+		"shellygen2_switch_voltage",
+		"shellygen2_sys_uptime_seconds",
+		"shellygen2_temperature_celsius",
+		"shellygen2_wifi_rssi",
+	)
+	require.NoError(t, err)
+}
@@ -0,0 +1,164 @@
+package gen2
+
+import (
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/tidwall/gjson"
+)
+
+// Field maps one value of a component status to a metric.
+type Field struct {
+	Path string // gjson path below the component, e.g. aenergy.total
+	Name string // metric name without shellygen2_<type>_
+	Help string
+	Type prometheus.ValueType
+}
+
+// FieldMapper exports a fixed set of fields per component as
+// shellygen2_<type>_<name>{device,id}. Booleans become 0 and 1, missing and
+// null fields are skipped.
+type FieldMapper struct {
+	fields []Field
+	descs  []*prometheus.Desc
+}
+
+func NewFieldMapper(typ string, fields ...Field) *FieldMapper {
+	m := &FieldMapper{fields: fields}
+	for _, f := range fields {
+		m.descs = append(m.descs, prometheus.NewDesc("shellygen2_"+typ+"_"+f.Name, f.Help, []string{"device", "id"}, nil))
+	}
+	return m
+}
+
+func (m *FieldMapper) Describe(ch chan<- *prometheus.Desc) {
+	for _, d := range m.descs {
+		ch <- d
+	}
+}
+
+func (m *FieldMapper) Collect(ch chan<- prometheus.Metric, device, id string, status gjson.Result) {
+	for i, f := range m.fields {
+		v := status.Get(f.Path)
+		var f64 float64
+		switch {
+		case !v.Exists() || v.Type == gjson.Null:
+			continue
+		case v.IsBool():
+			if v.Bool() {
+				f64 = 1
+			}
+		case v.Type == gjson.Number:
+			f64 = v.Float()
+		default:
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(m.descs[i], f.Type, f64, device, id)
+	}
+}
+
+func gauge(path, name, help string) Field {
+	return Field{Path: path, Name: name, Help: help, Type: prometheus.GaugeValue}
+}
+
+func counter(path, name, help string) Field {
+	return Field{Path: path, Name: name, Help: help, Type: prometheus.CounterValue}
+}
+
+// meterFields are shared by the switch, cover, pm1 and light components.
+var meterFields = []Field{
+	gauge("apower", "power", "instantaneous active power in Watts"),
+	gauge("voltage", "voltage", "voltage in Volts"),
+	gauge("current", "current", "current in Amps"),
+	gauge("pf", "pf", "power factor (dimensionless)"),
+	gauge("freq", "freq", "network frequency in Hz"),
+	counter("aenergy.total", "energy_total", "total active energy in Wh"),
+	counter("ret_aenergy.total", "returned_energy_total", "total returned active energy in Wh"),
+	gauge("temperature.tC", "temperature_celsius", "internal device temperature in °C"),
+}
+
+// emPhaseFields builds the fields of the three phase energy meter.
+func emPhaseFields() []Field {
+	var fields []Field
+	for _, phase := range []string{"a", "b", "c"} {
+		fields = append(fields,
+			gauge(phase+"_current", phase+"_current", "phase "+strings.ToUpper(phase)+" current in Amps"),
+			gauge(phase+"_voltage", phase+"_voltage", "phase "+strings.ToUpper(phase)+" voltage in Volts"),
+			gauge(phase+"_act_power", phase+"_act_power", "phase "+strings.ToUpper(phase)+" active power in Watts"),
+			gauge(phase+"_aprt_power", phase+"_aprt_power", "phase "+strings.ToUpper(phase)+" apparent power in VA"),
+			gauge(phase+"_pf", phase+"_pf", "phase "+strings.ToUpper(phase)+" power factor (dimensionless)"),
+			gauge(phase+"_freq", phase+"_freq", "phase "+strings.ToUpper(phase)+" network frequency in Hz"),
+		)
+	}
+	return append(fields,
+		gauge("n_current", "n_current", "neutral current in Amps"),
+		gauge("total_current", "total_current", "sum of the phase currents in Amps"),
+		gauge("total_act_power", "total_act_power", "sum of the active power in Watts"),
+		gauge("total_aprt_power", "total_aprt_power", "sum of the apparent power in VA"),
+	)
+}
+
+func init() {
+	Register("switch", NewFieldMapper("switch", append([]Field{
+		gauge("output", "output", "1 if the output is on"),
+	}, meterFields...)...))
+	Register("cover", NewFieldMapper("cover", append([]Field{
+		gauge("current_pos", "position", "current position in percent, 0 is fully closed"),
+		gauge("target_pos", "target_position", "target position in percent while the cover moves"),
+	}, meterFields...)...))
+	Register("light", NewFieldMapper("light", append([]Field{
+		gauge("output", "output", "1 if the light is on"),
+		gauge("brightness", "brightness", "brightness in percent"),
+	}, meterFields...)...))
+	Register("pm1", NewFieldMapper("pm1", meterFields...))
+	Register("em", NewFieldMapper("em", emPhaseFields()...))
+	Register("em1", NewFieldMapper("em1",
+		gauge("current", "current", "current in Amps"),
+		gauge("voltage", "voltage", "voltage in Volts"),
+		gauge("act_power", "act_power", "active power in Watts"),
+		gauge("aprt_power", "aprt_power", "apparent power in VA"),
+		gauge("pf", "pf", "power factor (dimensionless)"),
+		gauge("freq", "freq", "network frequency in Hz"),
+	))
+	Register("emdata", NewFieldMapper("emdata",
+		counter("total_act", "total_act_energy", "total active energy of all phases in Wh"),
+		counter("total_act_ret", "total_act_ret_energy", "total returned active energy of all phases in Wh"),
+	))
+	Register("em1data", NewFieldMapper("em1data",
+		counter("total_act_energy", "total_act_energy", "total active energy in Wh"),
+		counter("total_act_ret_energy", "total_act_ret_energy", "total returned active energy in Wh"),
+	))
+	Register("temperature", NewFieldMapper("temperature",
+		gauge("tC", "celsius", "temperature in °C"),
+	))
+	Register("humidity", NewFieldMapper("humidity",
+		gauge("rh", "percent", "relative humidity in percent"),
+	))
+	Register("devicepower", NewFieldMapper("devicepower",
+		gauge("battery.V", "battery_volts", "battery voltage in Volts"),
+		gauge("battery.percent", "battery_percent", "battery level in percent"),
+		gauge("external.present", "external_present", "1 if an external power source is connected"),
+	))
+	Register("input", NewFieldMapper("input",
+		gauge("state", "state", "state of the input, 1 if closed"),
+		gauge("percent", "percent", "value of an analog input in percent"),
+	))
+	Register("illuminance", NewFieldMapper("illuminance",
+		gauge("lux", "lux", "illuminance in lux"),
+	))
+	Register("voltmeter", NewFieldMapper("voltmeter",
+		gauge("voltage", "voltage", "voltage in Volts"),
+	))
+	Register("smoke", NewFieldMapper("smoke",
+		gauge("alarm", "alarm", "1 if smoke is detected"),
+		gauge("mute", "mute", "1 if the alarm is muted"),
+	))
+	Register("sys", NewFieldMapper("sys",
+		gauge("uptime", "uptime_seconds", "seconds since the last boot"),
+		gauge("ram_free", "ram_free_bytes", "free RAM in bytes"),
+		gauge("fs_free", "fs_free_bytes", "free file system space in bytes"),
+	))
+	Register("wifi", NewFieldMapper("wifi",
+		gauge("rssi", "rssi", "signal strength in dBm"),
+	))
+}
@@ -3,12 +3,15 @@ package ht
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"strings"
-	"time"
+	"sync"
 
+	"github.com/SchumacherFM/prometheus_shelly_exporter/collector"
 	mqtt "github.com/eclipse/paho.mqtt.golang"
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/tidwall/gjson"
 	"go.uber.org/zap"
 )
 
@@ -33,10 +36,12 @@ type Info struct {
 	} `json:"bat"`
 }
 
-type lastMSG struct {
-	time  time.Time
-	topic string
-	msg   mqtt.Message
+var errInvalidJSON = errors.New("ht: invalid JSON")
+
+// reading is the last valid info of a device.
+type reading struct {
+	id   string // device ID from the topic, which the presence knows
+	info Info
 }
 
 type Collector struct {
@@ -44,27 +49,37 @@ type Collector struct {
 	tmpDesc *prometheus.Desc
 	humDesc *prometheus.Desc
 	batDesc *prometheus.Desc
-	upDesc  *prometheus.Desc
-	lastMSG lastMSG
+	health  *collector.Health
+
+	mu       sync.Mutex
+	readings map[string]reading // MAC => last valid info
 }
 
 type Options struct {
-	Timeout time.Duration
-	Log     *zap.Logger
+	collector.Options
+	TestCB func()
+}
+
+func init() {
+	collector.Register(collector.Definition{
+		Name:          "ht",
+		Help:          "Shelly H&T (Gen1)",
+		Default:       true,
+		Subscriptions: []string{"shellies/+/info"},
+		New: func(ctx context.Context, messageChan <-chan mqtt.Message, opts collector.Options) prometheus.Collector {
+			return NewCollector(ctx, messageChan, Options{Options: opts})
+		},
+	})
 }
 
 func NewCollector(ctx context.Context, messageChan <-chan mqtt.Message, opts Options) *Collector {
 	c := &Collector{
-		opts:    opts,
-		tmpDesc: prometheus.NewDesc("shellyht_temperature", "Sensor temperature", []string{"device", "unit"}, nil),
-		humDesc: prometheus.NewDesc("shellyht_humidity", "Sensor humidity", []string{"device", "unit"}, nil),
-		batDesc: prometheus.NewDesc("shellyht_battery", "Sensor battery", []string{"device", "unit"}, nil),
-		upDesc:  prometheus.NewDesc("shellyht_up", "Whether scrape was successful", []string{"status"}, nil),
-		lastMSG: lastMSG{
-			time:  time.Now(),
-			topic: nullTopic,
-			msg:   nullMessage{},
-		},
+		opts:     opts,
+		tmpDesc:  prometheus.NewDesc("shellyht_temperature", "Sensor temperature", []string{"device", "unit"}, nil),
+		humDesc:  prometheus.NewDesc("shellyht_humidity", "Sensor humidity", []string{"device", "unit"}, nil),
+		batDesc:  prometheus.NewDesc("shellyht_battery", "Sensor battery", []string{"device", "unit"}, nil),
+		health:   collector.NewHealth("shellyht", opts.Options),
+		readings: make(map[string]reading),
 	}
 
 	go func() {
@@ -72,20 +87,15 @@ func NewCollector(ctx context.Context, messageChan <-chan mqtt.Message, opts Opt
 			select {
 			case msg, ok := <-messageChan:
 				if !ok {
+					if opts.TestCB != nil {
+						opts.TestCB()
+					}
 					return
 				}
 				if false == strings.HasSuffix(msg.Topic(), "/info") {
 					continue
 				}
-
-				c.lastMSG = lastMSG{
-					time:  time.Now(),
-					topic: msg.Topic(),
-					msg:   msg,
-				}
-				opts.Log.Debug("message from mqtt",
-					zap.String("topic", msg.Topic()),
-					zap.Int("length", len(msg.Payload())))
+				c.handleInfo(msg.Topic(), msg.Payload())
 
 			case <-ctx.Done():
 				return
@@ -96,73 +106,68 @@ func NewCollector(ctx context.Context, messageChan <-chan mqtt.Message, opts Opt
 	return c
 }
 
+func (c *Collector) handleInfo(topic string, payload []byte) {
+	if !gjson.ValidBytes(payload) {
+		c.health.ParseError("", topic, errInvalidJSON)
+		return
+	}
+	if false == gjson.GetBytes(payload, "hum").Exists() {
+		// info of another device type, e.g. TRV
+		return
+	}
+	var info Info
+	if err := json.Unmarshal(payload, &info); err != nil {
+		c.health.ParseError("", topic, fmt.Errorf("ht: %w", err))
+		return
+	}
+	c.opts.Log.Debug("message from mqtt",
+		zap.String("topic", topic),
+		zap.Int("length", len(payload)))
+
+	devID := info.Mac // MAC address if the device
+	c.opts.Timestamps.Observe(devID, gjson.GetBytes(payload, "unixtime").Float())
+
+	id := collector.DeviceFromTopic(topic)
+	c.health.SetID(devID, id)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !info.IsValid || !info.Tmp.IsValid || !info.Hum.IsValid {
+		// e.g. a broken sensor, don't export its readings
+		delete(c.readings, devID)
+		c.health.Error(devID, collector.ReasonInvalidReading)
+		return
+	}
+	c.readings[devID] = reading{id: id, info: info}
+	c.health.OK(devID)
+}
+
 func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
-	ch <- c.upDesc
+	c.health.Describe(ch)
 	ch <- c.tmpDesc
 	ch <- c.humDesc
 	ch <- c.batDesc
 }
 
 func (c *Collector) Collect(ch chan<- prometheus.Metric) {
-	ctx, cancel := context.WithTimeout(context.Background(), c.opts.Timeout)
-	defer cancel()
-
-	if err := c.collect(ctx, ch); err == nil {
-		ch <- prometheus.MustNewConstMetric(c.upDesc, prometheus.GaugeValue, 1, "")
-	} else {
-		c.opts.Log.Error("Scrape failed", zap.Error(err))
-		ch <- prometheus.MustNewConstMetric(c.upDesc, prometheus.GaugeValue, 0, err.Error())
-	}
+	c.collect(ch)
+	c.health.Collect(ch)
 }
 
-func (c *Collector) collect(ctx context.Context, ch chan<- prometheus.Metric) error {
-	switch {
-	case strings.HasSuffix(c.lastMSG.msg.Topic(), "/info"):
-		var info Info
-		if err := json.Unmarshal(c.lastMSG.msg.Payload(), &info); err != nil {
-			return fmt.Errorf("collect: json unmarshal failed: %w for data: %q", err, c.lastMSG.msg.Payload())
+func (c *Collector) collect(ch chan<- prometheus.Metric) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for devID, r := range c.readings {
+		if c.opts.Presence.Offline(r.id) {
+			continue
 		}
-		devID := info.Mac // MAC address if the device
+		info := r.info
 		ch <- prometheus.MustNewConstMetric(c.tmpDesc, prometheus.GaugeValue, info.Tmp.TC, devID, "c")
 		ch <- prometheus.MustNewConstMetric(c.tmpDesc, prometheus.GaugeValue, info.Tmp.TF, devID, "f")
 		ch <- prometheus.MustNewConstMetric(c.humDesc, prometheus.GaugeValue, info.Hum.Value, devID, "%")
 		ch <- prometheus.MustNewConstMetric(c.batDesc, prometheus.GaugeValue, info.Bat.Voltage, devID, "V")
 		ch <- prometheus.MustNewConstMetric(c.batDesc, prometheus.GaugeValue, float64(info.Bat.Value), devID, "%")
-
-	default:
-		c.opts.Log.Warn("unhandled topic", zap.String("topic", c.lastMSG.msg.Topic()))
 	}
-
-	return nil
-}
-
-const nullTopic = "shellies/null/info"
-
-type nullMessage struct{}
-
-func (nullMessage) Duplicate() bool {
-	return false
-}
-
-func (nullMessage) Qos() byte {
-	return 0
-}
-
-func (nullMessage) Retained() bool {
-	return false
-}
-
-func (nullMessage) Topic() string {
-	return nullTopic
-}
-
-func (nullMessage) MessageID() uint16 {
-	return 0
-}
-
-func (nullMessage) Payload() []byte {
-	return []byte(`{}`)
-}
-
-func (nullMessage) Ack() {
 }
@@ -0,0 +1,73 @@
+package ht
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/SchumacherFM/prometheus_shelly_exporter/collector"
+	"github.com/SchumacherFM/prometheus_shelly_exporter/collector/collectortest"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+var _ prometheus.Collector = (*Collector)(nil)
+
+func TestCollector_collect(t *testing.T) {
+	ctx := context.Background()
+	msgChan := make(chan mqtt.Message)
+
+	log, _ := zap.NewDevelopment(zap.Development())
+	presence := collector.NewPresence()
+	msgGoRoutineDone := make(chan struct{})
+	c := NewCollector(ctx, msgChan, Options{
+		Options: collector.Options{Log: log, Presence: presence},
+		TestCB: func() {
+			close(msgGoRoutineDone)
+		},
+	})
+
+	collectortest.SendFile(t, msgChan, "testdata/ht.txt")
+	close(msgChan)
+	<-msgGoRoutineDone
+	// the health knows the H&T by its MAC, the presence by its ID
+	presence.Set("shellyht-D4E5F6", false)
+
+	err := testutil.CollectAndCompare(c, strings.NewReader(`
+# HELP shellyht_battery Sensor battery
+# TYPE shellyht_battery gauge
+shellyht_battery{device="C45BBE6FDA5D",unit="%"} 91
+shellyht_battery{device="C45BBE6FDA5D",unit="V"} 2.91
+# HELP shellyht_humidity Sensor humidity
+# TYPE shellyht_humidity gauge
+shellyht_humidity{device="C45BBE6FDA5D",unit="%"} 47.5
+# HELP shellyht_scrape_errors_total readings of the device which failed
+# TYPE shellyht_scrape_errors_total counter
+shellyht_scrape_errors_total{device="485519A1B2C3",reason="invalid_reading"} 1
+shellyht_scrape_errors_total{device="485519A1B2C3",reason="parse_error"} 0
+shellyht_scrape_errors_total{device="485519A1B2C3",reason="stale"} 0
+shellyht_scrape_errors_total{device="485519D4E5F6",reason="invalid_reading"} 0
+shellyht_scrape_errors_total{device="485519D4E5F6",reason="parse_error"} 0
+shellyht_scrape_errors_total{device="485519D4E5F6",reason="stale"} 0
+shellyht_scrape_errors_total{device="C45BBE6FDA5D",reason="invalid_reading"} 0
+shellyht_scrape_errors_total{device="C45BBE6FDA5D",reason="parse_error"} 0
+shellyht_scrape_errors_total{device="C45BBE6FDA5D",reason="stale"} 0
+shellyht_scrape_errors_total{device="shellyht-0A0B0C",reason="invalid_reading"} 0
+shellyht_scrape_errors_total{device="shellyht-0A0B0C",reason="parse_error"} 1
+shellyht_scrape_errors_total{device="shellyht-0A0B0C",reason="stale"} 0
+# HELP shellyht_temperature Sensor temperature
+# TYPE shellyht_temperature gauge
+shellyht_temperature{device="C45BBE6FDA5D",unit="c"} 21.9
+shellyht_temperature{device="C45BBE6FDA5D",unit="f"} 71.42
+# HELP shellyht_up 1 if the last reading of the device was fine
+# TYPE shellyht_up gauge
+shellyht_up{device="485519A1B2C3"} 0
+shellyht_up{device="485519D4E5F6"} 0
+shellyht_up{device="C45BBE6FDA5D"} 1
+shellyht_up{device="shellyht-0A0B0C"} 0
+`))
+	require.NoError(t, err)
+}
@@ -3,12 +3,13 @@ package htgen3
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"strings"
-	"time"
+	"sync"
 
 	"github.com/tidwall/gjson"
 
+	"github.com/SchumacherFM/prometheus_shelly_exporter/collector"
 	mqtt "github.com/eclipse/paho.mqtt.golang"
 	"github.com/prometheus/client_golang/prometheus"
 	"go.uber.org/zap"
@@ -108,38 +109,44 @@ type Params struct {
 	Ws           Ws           `json:"ws"`
 }
 
-type lastMSG struct {
-	time  time.Time
-	topic string
-	msg   mqtt.Message
-}
+var errInvalidJSON = errors.New("htgen3: invalid JSON")
 
 type Collector struct {
 	opts    Options
 	tmpDesc *prometheus.Desc
 	humDesc *prometheus.Desc
 	batDesc *prometheus.Desc
-	upDesc  *prometheus.Desc
-	lastMSG lastMSG
+	health  *collector.Health
+
+	mu       sync.Mutex
+	readings map[string]Params // src => last valid NotifyFullStatus
 }
 
 type Options struct {
-	Timeout time.Duration
-	Log     *zap.Logger
+	collector.Options
+	TestCB func()
+}
+
+func init() {
+	collector.Register(collector.Definition{
+		Name:          "htgen3",
+		Help:          "Shelly H&T Gen3",
+		Default:       true,
+		Subscriptions: []string{"+/events/rpc"},
+		New: func(ctx context.Context, messageChan <-chan mqtt.Message, opts collector.Options) prometheus.Collector {
+			return NewCollector(ctx, messageChan, Options{Options: opts})
+		},
+	})
 }
 
 func NewCollector(ctx context.Context, messageChan <-chan mqtt.Message, opts Options) *Collector {
 	c := &Collector{
-		opts:    opts,
-		tmpDesc: prometheus.NewDesc("shellyhtgen3_temperature", "Sensor temperature", []string{"device", "unit"}, nil),
-		humDesc: prometheus.NewDesc("shellyhtgen3_humidity", "Sensor humidity", []string{"device", "unit"}, nil),
-		batDesc: prometheus.NewDesc("shellyhtgen3_battery", "Sensor battery", []string{"device", "unit"}, nil),
-		upDesc:  prometheus.NewDesc("shellyhtgen3_up", "Whether scrape was successful", []string{"status"}, nil),
-		lastMSG: lastMSG{
-			time:  time.Now(),
-			topic: nullTopic,
-			msg:   nullMessage{},
-		},
+		opts:     opts,
+		tmpDesc:  prometheus.NewDesc("shellyhtgen3_temperature", "Sensor temperature", []string{"device", "unit"}, nil),
+		humDesc:  prometheus.NewDesc("shellyhtgen3_humidity", "Sensor humidity", []string{"device", "unit"}, nil),
+		batDesc:  prometheus.NewDesc("shellyhtgen3_battery", "Sensor battery", []string{"device", "unit"}, nil),
+		health:   collector.NewHealth("shellyhtgen3", opts.Options),
+		readings: make(map[string]Params),
 	}
 
 	go func() {
@@ -147,21 +154,12 @@ func NewCollector(ctx context.Context, messageChan <-chan mqtt.Message, opts Opt
 			select {
 			case msg, ok := <-messageChan:
 				if !ok {
-					return
-				}
-
-				if r := gjson.GetBytes(msg.Payload(), "method"); r.String() == "NotifyFullStatus" {
-					opts.Log.Debug("message from mqtt",
-						zap.String("topic", msg.Topic()),
-						zap.Int("length", len(msg.Payload())))
-
-					// race condition but who cares, just old data gets overwritten
-					c.lastMSG = lastMSG{
-						time:  time.Now(),
-						topic: msg.Topic(),
-						msg:   msg,
+					if opts.TestCB != nil {
+						opts.TestCB()
 					}
+					return
 				}
+				c.handleStatus(msg.Topic(), msg.Payload())
 
 			case <-ctx.Done():
 				return
@@ -172,78 +170,67 @@ func NewCollector(ctx context.Context, messageChan <-chan mqtt.Message, opts Opt
 	return c
 }
 
-func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
-	ch <- c.upDesc
-	ch <- c.tmpDesc
-	ch <- c.humDesc
-	ch <- c.batDesc
-}
-
-func (c *Collector) Collect(ch chan<- prometheus.Metric) {
-	ctx, cancel := context.WithTimeout(context.Background(), c.opts.Timeout)
-	defer cancel()
-
-	if err := c.collect(ctx, ch); err == nil {
-		ch <- prometheus.MustNewConstMetric(c.upDesc, prometheus.GaugeValue, 1, "")
-	} else {
-		c.opts.Log.Error("Scrape failed", zap.Error(err))
-		ch <- prometheus.MustNewConstMetric(c.upDesc, prometheus.GaugeValue, 0, err.Error())
+func (c *Collector) handleStatus(topic string, payload []byte) {
+	if !gjson.ValidBytes(payload) {
+		c.health.ParseError("", topic, errInvalidJSON)
+		return
 	}
-}
-
-func (c *Collector) collect(ctx context.Context, ch chan<- prometheus.Metric) error {
-	switch {
-	case strings.HasSuffix(c.lastMSG.msg.Topic(), "/rpc"):
-		var info Event
-		if err := json.Unmarshal(c.lastMSG.msg.Payload(), &info); err != nil {
-			return fmt.Errorf("collect: json unmarshal failed: %w for data: %q", err, c.lastMSG.msg.Payload())
-		}
-		if info.Method != "NotifyFullStatus" {
-			// not interested in NotifyStatus
-			return nil
-		}
-
-		devID := info.Src // MAC address if the device
-		ch <- prometheus.MustNewConstMetric(c.tmpDesc, prometheus.GaugeValue, info.Params.Temperature0.TC, devID, "c")
-		ch <- prometheus.MustNewConstMetric(c.tmpDesc, prometheus.GaugeValue, info.Params.Temperature0.TF, devID, "f")
-		ch <- prometheus.MustNewConstMetric(c.humDesc, prometheus.GaugeValue, info.Params.Humidity0.Rh, devID, "%")
-		ch <- prometheus.MustNewConstMetric(c.batDesc, prometheus.GaugeValue, info.Params.Devicepower0.Battery.V, devID, "V")
-		ch <- prometheus.MustNewConstMetric(c.batDesc, prometheus.GaugeValue, float64(info.Params.Devicepower0.Battery.Percent), devID, "%")
-
-	default:
-		c.opts.Log.Warn("unhandled topic", zap.String("topic", c.lastMSG.msg.Topic()))
+	r := gjson.GetManyBytes(payload, "method", "params.temperature:0", "params.humidity:0")
+	// other Gen2 devices, like the ones with a Plus Add-on, also send
+	// NotifyFullStatus but without the H&T components. NotifyStatus is not
+	// interesting.
+	if r[0].String() != "NotifyFullStatus" || !r[1].Exists() {
+		return
 	}
+	var info Event
+	if err := json.Unmarshal(payload, &info); err != nil {
+		c.health.ParseError(gjson.GetBytes(payload, "src").String(), topic, fmt.Errorf("htgen3: %w", err))
+		return
+	}
+	c.opts.Log.Debug("message from mqtt",
+		zap.String("topic", topic),
+		zap.Int("length", len(payload)))
 
-	return nil
-}
-
-const nullTopic = "shellies/null/rpc"
-
-type nullMessage struct{}
+	devID := info.Src
+	c.opts.Timestamps.Observe(devID, info.Params.Ts)
 
-func (nullMessage) Duplicate() bool {
-	return false
-}
-
-func (nullMessage) Qos() byte {
-	return 0
-}
+	c.mu.Lock()
+	defer c.mu.Unlock()
 
-func (nullMessage) Retained() bool {
-	return false
+	// a sensor out of range reports null values and its errors
+	if r[1].Get("errors").Exists() || r[2].Get("errors").Exists() {
+		delete(c.readings, devID)
+		c.health.Error(devID, collector.ReasonInvalidReading)
+		return
+	}
+	c.readings[devID] = info.Params
+	c.health.OK(devID)
 }
 
-func (nullMessage) Topic() string {
-	return nullTopic
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	c.health.Describe(ch)
+	ch <- c.tmpDesc
+	ch <- c.humDesc
+	ch <- c.batDesc
 }
 
-func (nullMessage) MessageID() uint16 {
-	return 0
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	c.collect(ch)
+	c.health.Collect(ch)
 }
 
-func (nullMessage) Payload() []byte {
-	return []byte(`{}`)
-}
+func (c *Collector) collect(ch chan<- prometheus.Metric) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 
-func (nullMessage) Ack() {
+	for devID, p := range c.readings {
+		if c.opts.Presence.Offline(devID) {
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(c.tmpDesc, prometheus.GaugeValue, p.Temperature0.TC, devID, "c")
+		ch <- prometheus.MustNewConstMetric(c.tmpDesc, prometheus.GaugeValue, p.Temperature0.TF, devID, "f")
+		ch <- prometheus.MustNewConstMetric(c.humDesc, prometheus.GaugeValue, p.Humidity0.Rh, devID, "%")
+		ch <- prometheus.MustNewConstMetric(c.batDesc, prometheus.GaugeValue, p.Devicepower0.Battery.V, devID, "V")
+		ch <- prometheus.MustNewConstMetric(c.batDesc, prometheus.GaugeValue, float64(p.Devicepower0.Battery.Percent), devID, "%")
+	}
 }
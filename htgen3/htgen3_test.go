@@ -0,0 +1,76 @@
+package htgen3
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/SchumacherFM/prometheus_shelly_exporter/collector"
+	"github.com/SchumacherFM/prometheus_shelly_exporter/collector/collectortest"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+var _ prometheus.Collector = (*Collector)(nil)
+
+func TestCollector_collect(t *testing.T) {
+	ctx := context.Background()
+	msgChan := make(chan mqtt.Message)
+
+	log, _ := zap.NewDevelopment(zap.Development())
+	presence := collector.NewPresence()
+	msgGoRoutineDone := make(chan struct{})
+	c := NewCollector(ctx, msgChan, Options{
+		Options: collector.Options{Log: log, Presence: presence},
+		TestCB: func() {
+			close(msgGoRoutineDone)
+		},
+	})
+
+	collectortest.SendFile(t, msgChan, "testdata/htgen3.txt")
+	close(msgChan)
+	<-msgGoRoutineDone
+	presence.Set("shellyhtg3-e4b3233a7f80", false)
+
+	err := testutil.CollectAndCompare(c, strings.NewReader(`
+# HELP shellyhtgen3_battery Sensor battery
+# TYPE shellyhtgen3_battery gauge
+shellyhtgen3_battery{device="shellyhtg3-e4b3233a1b2c",unit="%"} 95
+shellyhtgen3_battery{device="shellyhtg3-e4b3233a1b2c",unit="V"} 5.94
+# HELP shellyhtgen3_humidity Sensor humidity
+# TYPE shellyhtgen3_humidity gauge
+shellyhtgen3_humidity{device="shellyhtg3-e4b3233a1b2c",unit="%"} 50.5
+# HELP shellyhtgen3_scrape_errors_total readings of the device which failed
+# TYPE shellyhtgen3_scrape_errors_total counter
+shellyhtgen3_scrape_errors_total{device="shellyhtg3-e4b3233a1b2c",reason="invalid_reading"} 0
+shellyhtgen3_scrape_errors_total{device="shellyhtg3-e4b3233a1b2c",reason="parse_error"} 0
+shellyhtgen3_scrape_errors_total{device="shellyhtg3-e4b3233a1b2c",reason="stale"} 0
+shellyhtgen3_scrape_errors_total{device="shellyhtg3-e4b3233a4d5e",reason="invalid_reading"} 1
+shellyhtgen3_scrape_errors_total{device="shellyhtg3-e4b3233a4d5e",reason="parse_error"} 0
+shellyhtgen3_scrape_errors_total{device="shellyhtg3-e4b3233a4d5e",reason="stale"} 0
+shellyhtgen3_scrape_errors_total{device="shellyhtg3-e4b3233a7f80",reason="invalid_reading"} 0
+shellyhtgen3_scrape_errors_total{device="shellyhtg3-e4b3233a7f80",reason="parse_error"} 0
+shellyhtgen3_scrape_errors_total{device="shellyhtg3-e4b3233a7f80",reason="stale"} 0
+shellyhtgen3_scrape_errors_total{device="shellyhtg3-e4b3233a9a9b",reason="invalid_reading"} 0
+shellyhtgen3_scrape_errors_total{device="shellyhtg3-e4b3233a9a9b",reason="parse_error"} 1
+shellyhtgen3_scrape_errors_total{device="shellyhtg3-e4b3233a9a9b",reason="stale"} 0
+shellyhtgen3_scrape_errors_total{device="shellyhtg3-e4b3233aabcd",reason="invalid_reading"} 0
+shellyhtgen3_scrape_errors_total{device="shellyhtg3-e4b3233aabcd",reason="parse_error"} 1
+shellyhtgen3_scrape_errors_total{device="shellyhtg3-e4b3233aabcd",reason="stale"} 0
+# HELP shellyhtgen3_temperature Sensor temperature
+# TYPE shellyhtgen3_temperature gauge
+shellyhtgen3_temperature{device="shellyhtg3-e4b3233a1b2c",unit="c"} 22.1
+shellyhtgen3_temperature{device="shellyhtg3-e4b3233a1b2c",unit="f"} 71.8
+# HELP shellyhtgen3_up 1 if the last reading of the device was fine
+# TYPE shellyhtgen3_up gauge
+shellyhtgen3_up{device="shellyhtg3-e4b3233a1b2c"} 1
+shellyhtgen3_up{device="shellyhtg3-e4b3233a4d5e"} 0
+shellyhtgen3_up{device="shellyhtg3-e4b3233a7f80"} 0
+shellyhtgen3_up{device="shellyhtg3-e4b3233a9a9b"} 0
+shellyhtgen3_up{device="shellyhtg3-e4b3233aabcd"} 0
+`))
+	require.NoError(t, err)
+}
@@ -0,0 +1,271 @@
+package input
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/SchumacherFM/prometheus_shelly_exporter/collector"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/tidwall/gjson"
+	"go.uber.org/zap"
+)
+
+// Gen2 devices report the state of input:<N> in the RPC status notifications
+// and button presses as NotifyEvent:
+//
+//	{"method":"NotifyEvent","params":{"events":[{"component":"input:0","id":0,"event":"single_push"}]}}
+//
+// The state of the Plus Add-on inputs, ID 100 and above, is exported by the
+// plusaddon collector, only their button events are counted here.
+// Gen1 Shelly i3, 1 and 2.5 publish the state on shellies/<id>/input/<N> and
+// the last button event with a counter on shellies/<id>/input_event/<N>:
+//
+//	{"event":"SS","event_cnt":4}
+//
+// Gen1 event codes get mapped to the Gen2 event names.
+var gen1Events = map[string]string{
+	"S":   "single_push",
+	"SS":  "double_push",
+	"SSS": "triple_push",
+	"L":   "long_push",
+	"SL":  "short_long_push",
+	"LS":  "long_short_push",
+}
+
+// gen2Events lists the exported Gen2 events, anything else, like a toggle
+// of a switch input, is ignored.
+var gen2Events = map[string]bool{
+	"btn_down":    true,
+	"btn_up":      true,
+	"single_push": true,
+	"double_push": true,
+	"triple_push": true,
+	"long_push":   true,
+}
+
+type input struct {
+	state    float64
+	hasState bool
+	eventCnt int64 // Gen1 event_cnt of the last event, -1 before the first
+	events   map[string]float64
+}
+
+type Collector struct {
+	opts       Options
+	stateDesc  *prometheus.Desc
+	eventsDesc *prometheus.Desc
+	health     *collector.Health
+
+	mu      sync.Mutex
+	devices map[string]map[string]*input // device ID => input ID => state
+}
+
+type Options struct {
+	collector.Options
+	TestCB func()
+}
+
+func init() {
+	collector.Register(collector.Definition{
+		Name:          "input",
+		Help:          "Gen1 and Gen2 inputs and button events",
+		Default:       true,
+		Subscriptions: []string{"shellies/+/input/+", "shellies/+/input_event/+", "+/events/rpc"},
+		New: func(ctx context.Context, messageChan <-chan mqtt.Message, opts collector.Options) prometheus.Collector {
+			return NewCollector(ctx, messageChan, Options{Options: opts})
+		},
+	})
+}
+
+func NewCollector(ctx context.Context, messageChan <-chan mqtt.Message, opts Options) *Collector {
+	c := &Collector{
+		opts:       opts,
+		stateDesc:  prometheus.NewDesc("shellyinput_state", "state of the input, 1 if closed", []string{"device", "input"}, nil),
+		eventsDesc: prometheus.NewDesc("shellyinput_events_total", "button events since the exporter started", []string{"device", "input", "event"}, nil),
+		health:     collector.NewHealth("shellyinput", opts.Options),
+		devices:    make(map[string]map[string]*input),
+	}
+
+	go func() {
+		for {
+			select {
+			case msg, ok := <-messageChan:
+				if !ok {
+					if opts.TestCB != nil {
+						opts.TestCB()
+					}
+					return
+				}
+
+				switch {
+				case strings.HasPrefix(msg.Topic(), "shellies/"):
+					c.handleGen1(msg.Topic(), msg.Payload())
+				case strings.HasSuffix(msg.Topic(), "/rpc"):
+					c.handleGen2(msg.Topic(), msg.Payload())
+				}
+
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return c
+}
+
+// input returns the state of the input and creates it if needed. The caller
+// must hold c.mu.
+func (c *Collector) input(devID, inputID string) *input {
+	inputs, ok := c.devices[devID]
+	if !ok {
+		inputs = make(map[string]*input)
+		c.devices[devID] = inputs
+	}
+	in, ok := inputs[inputID]
+	if !ok {
+		in = &input{eventCnt: -1, events: make(map[string]float64)}
+		inputs[inputID] = in
+	}
+	return in
+}
+
+func (c *Collector) handleGen1(topic string, payload []byte) {
+	// shellies/<id>/input_event/0
+	topicPaths := strings.Split(topic, "/")
+	if len(topicPaths) != 4 || (topicPaths[2] != "input" && topicPaths[2] != "input_event") {
+		return
+	}
+	devID, inputID := topicPaths[1], topicPaths[3]
+
+	c.opts.Log.Debug("message from mqtt",
+		zap.String("topic", topic),
+		zap.Int("length", len(payload)))
+
+	if topicPaths[2] == "input" {
+		f64, err := strconv.ParseFloat(strings.TrimSpace(string(payload)), 64)
+		if err != nil {
+			c.health.ParseError(devID, topic, err)
+			return
+		}
+		c.opts.Timestamps.Observe(devID, 0)
+		c.health.OK(devID)
+		c.mu.Lock()
+		in := c.input(devID, inputID)
+		in.state, in.hasState = f64, true
+		c.mu.Unlock()
+		return
+	}
+
+	r := gjson.ParseBytes(payload)
+	// the event is empty after boot
+	event, ok := gen1Events[r.Get("event").String()]
+	cnt := r.Get("event_cnt").Int()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// the payload is retained and published again on reconnect, only a new
+	// event_cnt is a new event. The first payload after the start of the
+	// exporter is the baseline, it may be an event from long ago.
+	in := c.input(devID, inputID)
+	if in.eventCnt >= 0 && cnt != in.eventCnt && ok {
+		in.events[event]++
+	}
+	if cnt != in.eventCnt {
+		in.eventCnt = cnt
+		c.opts.Timestamps.Observe(devID, 0)
+		c.health.OK(devID)
+	}
+}
+
+func (c *Collector) handleGen2(topic string, payload []byte) {
+	r := gjson.ParseBytes(payload)
+	devID := r.Get("src").String()
+	if devID == "" {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var found bool
+	switch r.Get("method").String() {
+	case "NotifyStatus", "NotifyFullStatus":
+		r.Get("params").ForEach(func(key, value gjson.Result) bool {
+			inputID, ok := strings.CutPrefix(key.String(), "input:")
+			if !ok {
+				return true
+			}
+			v := value.Get("state")
+			if !v.IsBool() || addonInput(inputID) {
+				// button, analog or add-on input
+				return true
+			}
+			found = true
+			in := c.input(devID, inputID)
+			in.state, in.hasState = 0, true
+			if v.Bool() {
+				in.state = 1
+			}
+			return true
+		})
+	case "NotifyEvent":
+		r.Get("params.events").ForEach(func(_, event gjson.Result) bool {
+			inputID, ok := strings.CutPrefix(event.Get("component").String(), "input:")
+			name := event.Get("event").String()
+			if !ok || !gen2Events[name] {
+				return true
+			}
+			found = true
+			c.input(devID, inputID).events[name]++
+			return true
+		})
+	}
+
+	if found {
+		c.opts.Timestamps.Observe(devID, r.Get("params.ts").Float())
+		c.health.OK(devID)
+		c.opts.Log.Debug("message from mqtt",
+			zap.String("topic", topic),
+			zap.Int("length", len(payload)))
+	}
+}
+
+// addonInput reports whether the input ID belongs to the Plus Add-on.
+func addonInput(inputID string) bool {
+	id, err := strconv.Atoi(inputID)
+	return err == nil && id >= 100
+}
+
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.stateDesc
+	ch <- c.eventsDesc
+	c.health.Describe(ch)
+}
+
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	c.collect(ch)
+	c.health.Collect(ch)
+}
+
+func (c *Collector) collect(ch chan<- prometheus.Metric) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for devID, inputs := range c.devices {
+		if c.opts.Presence.Offline(devID) {
+			continue
+		}
+		for inputID, in := range inputs {
+			if in.hasState {
+				ch <- prometheus.MustNewConstMetric(c.stateDesc, prometheus.GaugeValue, in.state, devID, inputID)
+			}
+			for event, count := range in.events {
+				ch <- prometheus.MustNewConstMetric(c.eventsDesc, prometheus.CounterValue, count, devID, inputID, event)
+			}
+		}
+	}
+}
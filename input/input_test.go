@@ -0,0 +1,97 @@
+package input
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/SchumacherFM/prometheus_shelly_exporter/collector"
+	"github.com/SchumacherFM/prometheus_shelly_exporter/collector/collectortest"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+var _ prometheus.Collector = (*Collector)(nil)
+
+func TestCollector_collect(t *testing.T) {
+	ctx := context.Background()
+	msgChan := make(chan mqtt.Message)
+
+	log, _ := zap.NewDevelopment(zap.Development())
+	msgGoRoutineDone := make(chan struct{})
+	c := NewCollector(ctx, msgChan, Options{
+		Options: collector.Options{Log: log},
+		TestCB: func() {
+			close(msgGoRoutineDone)
+		},
+	})
+
+	collectortest.SendFile(t, msgChan, "testdata/input.txt")
+	close(msgChan)
+	<-msgGoRoutineDone
+
+	err := testutil.CollectAndCompare(c, strings.NewReader(`
+# HELP shellyinput_events_total button events since the exporter started
+# TYPE shellyinput_events_total counter
+shellyinput_events_total{device="shellyix3-98CDAC1F2A3B",event="double_push",input="0"} 1
+shellyinput_events_total{device="shellyix3-98CDAC1F2A3B",event="long_push",input="1"} 1
+shellyinput_events_total{device="shellyix3-98CDAC1F2A3B",event="single_push",input="0"} 1
+shellyinput_events_total{device="shellyplusi4-a8032ab12345",event="btn_down",input="1"} 1
+shellyinput_events_total{device="shellyplusi4-a8032ab12345",event="long_push",input="1"} 1
+shellyinput_events_total{device="shellyplusi4-a8032ab12345",event="single_push",input="1"} 1
+# HELP shellyinput_state state of the input, 1 if closed
+# TYPE shellyinput_state gauge
+shellyinput_state{device="shellyix3-98CDAC1F2A3B",input="0"} 0
+shellyinput_state{device="shellyix3-98CDAC1F2A3B",input="1"} 1
+shellyinput_state{device="shellyplus1-a8032ab67890",input="0"} 1
+shellyinput_state{device="shellyplusi4-a8032ab12345",input="0"} 1
+# HELP shellyinput_up 1 if the last reading of the device was fine
+# TYPE shellyinput_up gauge
+shellyinput_up{device="shellyix3-98CDAC1F2A3B"} 1
+shellyinput_up{device="shellyplus1-a8032ab67890"} 1
+shellyinput_up{device="shellyplusi4-a8032ab12345"} 1
+`),
+		"shellyinput_events_total",
+		"shellyinput_state",
+		"shellyinput_up",
+	)
+	require.NoError(t, err)
+}
+
+func TestCollector_retainedEvent(t *testing.T) {
+	// the broker delivers the retained input_event again to each new
+	// collector, e.g. after a restart of the exporter
+	const retained = `{"event":"S","event_cnt":4}`
+	for _, payloads := range [][]string{
+		{retained},
+		{retained, `{"event":"SS","event_cnt":5}`},
+	} {
+		msgChan := make(chan mqtt.Message)
+		msgGoRoutineDone := make(chan struct{})
+		c := NewCollector(context.Background(), msgChan, Options{
+			Options: collector.Options{Log: zap.NewNop()},
+			TestCB: func() {
+				close(msgGoRoutineDone)
+			},
+		})
+		for _, payload := range payloads {
+			msgChan <- collectortest.NewRetained("shellies/shellyix3-98CDAC1F2A3B/input_event/0", payload)
+		}
+		close(msgChan)
+		<-msgGoRoutineDone
+
+		want := ""
+		if len(payloads) > 1 {
+			want = `
+# HELP shellyinput_events_total button events since the exporter started
+# TYPE shellyinput_events_total counter
+shellyinput_events_total{device="shellyix3-98CDAC1F2A3B",event="double_push",input="0"} 1
+`
+		}
+		err := testutil.CollectAndCompare(c, strings.NewReader(want), "shellyinput_events_total")
+		require.NoError(t, err)
+	}
+}
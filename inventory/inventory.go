@@ -0,0 +1,283 @@
+package inventory
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/SchumacherFM/prometheus_shelly_exporter/collector"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/tidwall/gjson"
+	"go.uber.org/zap"
+)
+
+// Gen1 devices publish their identity on shellies/announce after connecting
+// or when asked with "announce" on shellies/<id>/command:
+//
+//	{"id":"shellyht-6FDA5D","model":"SHHT-1","mac":"C45BBE6FDA5D","ip":"192.168.1.8","new_fw":false,"fw_ver":"20230913-112003/v1.14.0-gcb84623"}
+//
+// shellies/<id>/info keeps mac, IP and update state current.
+//
+// Gen2 devices answer Shelly.GetDeviceInfo on the topic of the dst of the
+// request, with requests enabled the collector sends it to <prefix>/rpc for
+// each new device:
+//
+//	{"id":1,"src":"shellyplus1pm-a8032ab12345","dst":"shelly_exporter","result":{"id":"shellyplus1pm-a8032ab12345",
+//	  "mac":"A8032AB12345","model":"SNSW-001P16EU","gen":2,"ver":"1.0.8"}}
+//
+// The IP and available updates come from wifi and sys in the status
+// notifications.
+
+// RPCSource is the src of the RPC requests, the responses arrive on
+// RPCSource/rpc.
+const RPCSource = "shelly_exporter"
+
+type device struct {
+	model, mac, ip, fwVer, gen string
+	update                     float64
+	hasUpdate                  bool
+	requested                  time.Time // last announce or GetDeviceInfo request
+}
+
+type Collector struct {
+	opts       Options
+	infoDesc   *prometheus.Desc
+	updateDesc *prometheus.Desc
+	health     *collector.Health
+
+	mu      sync.Mutex
+	devices map[string]*device // device ID => inventory
+}
+
+type Options struct {
+	collector.Options
+	TestCB func()
+}
+
+func init() {
+	collector.Register(collector.Definition{
+		Name:          "inventory",
+		Help:          "model, MAC, IP and firmware of Gen1 and Gen2 devices",
+		Default:       true,
+		Subscriptions: []string{"shellies/announce", "shellies/+/info", "+/events/rpc", RPCSource + "/rpc"},
+		Requests:      true,
+		New: func(ctx context.Context, messageChan <-chan mqtt.Message, opts collector.Options) prometheus.Collector {
+			return NewCollector(ctx, messageChan, Options{Options: opts})
+		},
+	})
+}
+
+func NewCollector(ctx context.Context, messageChan <-chan mqtt.Message, opts Options) *Collector {
+	c := &Collector{
+		opts:       opts,
+		infoDesc:   prometheus.NewDesc("shelly_device_info", "device inventory, always 1", []string{"device", "model", "mac", "ip", "fw_ver", "gen"}, nil),
+		updateDesc: prometheus.NewDesc("shelly_firmware_update_available", "1 if a newer stable firmware is available", []string{"device"}, nil),
+		health:     collector.NewHealth("shelly_inventory", opts.Options),
+		devices:    make(map[string]*device),
+	}
+
+	go func() {
+		for {
+			select {
+			case msg, ok := <-messageChan:
+				if !ok {
+					if opts.TestCB != nil {
+						opts.TestCB()
+					}
+					return
+				}
+
+				switch topic := msg.Topic(); {
+				case topic == "shellies/announce":
+					c.handleAnnounce(topic, msg.Payload())
+				case strings.HasPrefix(topic, "shellies/") && strings.HasSuffix(topic, "/info"):
+					c.handleInfo(topic, msg.Payload())
+				case topic == RPCSource+"/rpc":
+					c.handleResponse(topic, msg.Payload())
+				case strings.HasSuffix(topic, "/events/rpc"):
+					c.handleStatus(topic, msg.Payload())
+				}
+
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return c
+}
+
+// device returns the inventory of the device ID and creates it if needed. The
+// caller must hold c.mu.
+func (c *Collector) device(devID string) *device {
+	d, ok := c.devices[devID]
+	if !ok {
+		d = &device{}
+		c.devices[devID] = d
+	}
+	return d
+}
+
+// request publishes the payload if requests are enabled, the model of the
+// device is still unknown and the last request is older than the request
+// interval. The caller must hold c.mu.
+func (c *Collector) request(d *device, topic string, payload []byte) {
+	if c.opts.Publish == nil || d.model != "" || time.Since(d.requested) < c.opts.RequestInterval {
+		return
+	}
+	d.requested = time.Now()
+	c.opts.Log.Debug("requesting device info", zap.String("topic", topic))
+	c.opts.Publish(topic, payload)
+}
+
+func (c *Collector) handleAnnounce(topic string, payload []byte) {
+	r := gjson.ParseBytes(payload)
+	devID := r.Get("id").String()
+	if devID == "" {
+		c.health.ParseError("", topic, errors.New("inventory: announce without id"))
+		return
+	}
+
+	c.opts.Log.Debug("message from mqtt",
+		zap.String("topic", topic),
+		zap.Int("length", len(payload)))
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	d := c.device(devID)
+	c.health.OK(devID)
+	d.model = r.Get("model").String()
+	d.mac = r.Get("mac").String()
+	d.ip = r.Get("ip").String()
+	d.fwVer = r.Get("fw_ver").String()
+	d.gen = "1"
+	if v := r.Get("new_fw"); v.Exists() {
+		d.update, d.hasUpdate = boolToFloat(v.Bool()), true
+	}
+}
+
+func (c *Collector) handleInfo(topic string, payload []byte) {
+	// shellies/<id>/info
+	topicPaths := strings.Split(topic, "/")
+	if len(topicPaths) != 3 {
+		return
+	}
+	devID := topicPaths[1]
+	if !gjson.ValidBytes(payload) {
+		c.health.ParseError(devID, topic, errors.New("inventory: invalid JSON"))
+		return
+	}
+	r := gjson.ParseBytes(payload)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	d := c.device(devID)
+	c.health.OK(devID)
+	d.gen = "1"
+	if v := r.Get("mac"); v.Exists() {
+		d.mac = v.String()
+	}
+	if v := r.Get("wifi_sta.ip"); v.Exists() {
+		d.ip = v.String()
+	}
+	if v := r.Get("update.has_update"); v.Exists() {
+		d.update, d.hasUpdate = boolToFloat(v.Bool()), true
+	}
+	c.request(d, "shellies/"+devID+"/command", []byte("announce"))
+}
+
+func (c *Collector) handleStatus(topic string, payload []byte) {
+	r := gjson.ParseBytes(payload)
+	devID := r.Get("src").String()
+	if devID == "" {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	d := c.device(devID)
+	c.health.OK(devID)
+	if d.gen == "" {
+		d.gen = "2" // until GetDeviceInfo tells otherwise
+	}
+	if m := r.Get("method").String(); m == "NotifyStatus" || m == "NotifyFullStatus" {
+		if v := r.Get("params.wifi.sta_ip"); v.Exists() {
+			d.ip = v.String()
+		}
+		if v := r.Get("params.sys.mac"); v.Exists() {
+			d.mac = v.String()
+		}
+		if v := r.Get("params.sys.available_updates"); v.Exists() {
+			d.update, d.hasUpdate = boolToFloat(v.Get("stable").Exists()), true
+		}
+	}
+
+	// responses are matched by their src, the request ID doesn't matter.
+	prefix := strings.TrimSuffix(topic, "/events/rpc")
+	c.request(d, prefix+"/rpc", fmt.Appendf(nil,
+		`{"id":1,"src":%q,"method":"Shelly.GetDeviceInfo"}`, RPCSource))
+}
+
+func (c *Collector) handleResponse(topic string, payload []byte) {
+	r := gjson.ParseBytes(payload)
+	devID, result := r.Get("src").String(), r.Get("result")
+	if devID == "" || !result.IsObject() {
+		if e := r.Get("error"); e.Exists() {
+			c.opts.Log.Error("RPC request failed", zap.String("topic", topic), zap.String("error", e.Raw))
+		}
+		return
+	}
+
+	c.opts.Log.Debug("message from mqtt",
+		zap.String("topic", topic),
+		zap.Int("length", len(payload)))
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	d := c.device(devID)
+	c.health.OK(devID)
+	d.model = result.Get("model").String()
+	d.mac = result.Get("mac").String()
+	d.fwVer = result.Get("ver").String()
+	d.gen = result.Get("gen").String()
+}
+
+func boolToFloat(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.infoDesc
+	ch <- c.updateDesc
+	c.health.Describe(ch)
+}
+
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	c.collect(ch)
+	c.health.Collect(ch)
+}
+
+func (c *Collector) collect(ch chan<- prometheus.Metric) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for devID, d := range c.devices {
+		if d.model != "" || d.mac != "" {
+			ch <- prometheus.MustNewConstMetric(c.infoDesc, prometheus.GaugeValue, 1, devID, d.model, d.mac, d.ip, d.fwVer, d.gen)
+		}
+		if d.hasUpdate {
+			ch <- prometheus.MustNewConstMetric(c.updateDesc, prometheus.GaugeValue, d.update, devID)
+		}
+	}
+}
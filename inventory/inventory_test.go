@@ -0,0 +1,75 @@
+package inventory
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/SchumacherFM/prometheus_shelly_exporter/collector"
+	"github.com/SchumacherFM/prometheus_shelly_exporter/collector/collectortest"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+var _ prometheus.Collector = (*Collector)(nil)
+
+func TestCollector_collect(t *testing.T) {
+	ctx := context.Background()
+	msgChan := make(chan mqtt.Message)
+
+	log, _ := zap.NewDevelopment(zap.Development())
+	msgGoRoutineDone := make(chan struct{})
+	var published []string
+	c := NewCollector(ctx, msgChan, Options{
+		Options: collector.Options{
+			RequestInterval: time.Hour,
+			Log:             log,
+			Publish: func(topic string, payload []byte) {
+				published = append(published, topic+" "+string(payload))
+			},
+		},
+		TestCB: func() {
+			close(msgGoRoutineDone)
+		},
+	})
+
+	collectortest.SendFile(t, msgChan, "testdata/inventory.txt")
+	close(msgChan)
+	<-msgGoRoutineDone
+
+	require.Equal(t, []string{
+		"shellies/shellyplug-s-A1B2C3/command announce",
+		"shellyplus1pm-a8032ab12345/rpc {\"id\":1,\"src\":\"shelly_exporter\",\"method\":\"Shelly.GetDeviceInfo\"}",
+		"shellyhtg3-e4b063d4a1b2/rpc {\"id\":1,\"src\":\"shelly_exporter\",\"method\":\"Shelly.GetDeviceInfo\"}",
+	}, published)
+
+	err := testutil.CollectAndCompare(c, strings.NewReader(`
+# HELP shelly_device_info device inventory, always 1
+# TYPE shelly_device_info gauge
+shelly_device_info{device="shellyht-6FDA5D",fw_ver="20230913-112003/v1.14.0-gcb84623",gen="1",ip="192.168.1.9",mac="C45BBE6FDA5D",model="SHHT-1"} 1
+shelly_device_info{device="shellyplug-s-A1B2C3",fw_ver="",gen="1",ip="192.168.1.10",mac="A4CF12A1B2C3",model=""} 1
+shelly_device_info{device="shellyplus1pm-a8032ab12345",fw_ver="1.0.8",gen="2",ip="192.168.1.20",mac="A8032AB12345",model="SNSW-001P16EU"} 1
+# HELP shelly_firmware_update_available 1 if a newer stable firmware is available
+# TYPE shelly_firmware_update_available gauge
+shelly_firmware_update_available{device="shellyhtg3-e4b063d4a1b2"} 0
+shelly_firmware_update_available{device="shellyht-6FDA5D"} 1
+shelly_firmware_update_available{device="shellyplug-s-A1B2C3"} 0
+shelly_firmware_update_available{device="shellyplus1pm-a8032ab12345"} 1
+# HELP shelly_inventory_up 1 if the last reading of the device was fine
+# TYPE shelly_inventory_up gauge
+shelly_inventory_up{device="shelly1-98CDAC1F2A3B"} 0
+shelly_inventory_up{device="shellyht-6FDA5D"} 1
+shelly_inventory_up{device="shellyhtg3-e4b063d4a1b2"} 1
+shelly_inventory_up{device="shellyplug-s-A1B2C3"} 1
+shelly_inventory_up{device="shellyplus1pm-a8032ab12345"} 1
+`),
+		"shelly_device_info",
+		"shelly_firmware_update_available",
+		"shelly_inventory_up",
+	)
+	require.NoError(t, err)
+}
@@ -1,17 +1,36 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"log"
 	"log/slog"
 	"net/http"
-	"net/url"
 	"os"
+	"os/signal"
+	"reflect"
+	"strings"
+	"syscall"
 	"time"
 
-	"github.com/SchumacherFM/prometheus_shelly_exporter/ht"
-	"github.com/SchumacherFM/prometheus_shelly_exporter/htgen3"
-	"github.com/SchumacherFM/prometheus_shelly_exporter/threeem"
+	_ "github.com/SchumacherFM/prometheus_shelly_exporter/addon"
+	_ "github.com/SchumacherFM/prometheus_shelly_exporter/bthome"
+	"github.com/SchumacherFM/prometheus_shelly_exporter/collector"
+	"github.com/SchumacherFM/prometheus_shelly_exporter/config"
+	_ "github.com/SchumacherFM/prometheus_shelly_exporter/cover"
+	"github.com/SchumacherFM/prometheus_shelly_exporter/devicemap"
+	_ "github.com/SchumacherFM/prometheus_shelly_exporter/gas"
+	_ "github.com/SchumacherFM/prometheus_shelly_exporter/gen2"
+	_ "github.com/SchumacherFM/prometheus_shelly_exporter/ht"
+	_ "github.com/SchumacherFM/prometheus_shelly_exporter/htgen3"
+	_ "github.com/SchumacherFM/prometheus_shelly_exporter/input"
+	_ "github.com/SchumacherFM/prometheus_shelly_exporter/inventory"
+	_ "github.com/SchumacherFM/prometheus_shelly_exporter/motion"
+	_ "github.com/SchumacherFM/prometheus_shelly_exporter/online"
+	_ "github.com/SchumacherFM/prometheus_shelly_exporter/plusaddon"
+	_ "github.com/SchumacherFM/prometheus_shelly_exporter/safety"
+	_ "github.com/SchumacherFM/prometheus_shelly_exporter/threeem"
+	_ "github.com/SchumacherFM/prometheus_shelly_exporter/trv"
 	"github.com/eclipse/paho.mqtt.golang"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/collectors"
@@ -36,7 +55,7 @@ func main() {
 			{
 				Name:  "prom",
 				Usage: "forwards the mqtt data towards prometheus",
-				Flags: []cli.Flag{
+				Flags: append([]cli.Flag{
 					&cli.StringFlag{
 						Name:    "http-listen-address",
 						Value:   ":80",
@@ -51,17 +70,34 @@ func main() {
 						Value: false,
 						Usage: "if true sends metrics about the go runtime",
 					},
-				},
+					&cli.StringFlag{
+						Name:  "device-map",
+						Usage: "YAML file with name, room, floor and extra labels per device, reloaded on SIGHUP",
+					},
+					&cli.BoolFlag{
+						Name:  "device-timestamps",
+						Usage: "attach the time of the last reading, reported by the device or the receive time, to the samples",
+					},
+					&cli.IntFlag{
+						Name:  "queue-size",
+						Value: 100,
+						Usage: "messages buffered per collector, at least 1, further messages get dropped",
+					},
+				}, collectorFlags()...),
 				Action: actionProm,
 			},
 		},
 		Usage: "Converts received data from MQTT towards prometheus format",
 		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:    "config.file",
+				Usage:   "YAML configuration file, flags set on the command line take precedence. SIGHUP reloads subscriptions and devices",
+				EnvVars: []string{"CONFIG_FILE"},
+			},
 			&cli.StringSliceFlag{
-				Name:     "mqtt-url",
-				Required: true,
-				Usage:    "mqtt://hostname:port",
-				EnvVars:  []string{"MQTT_HOSTS"},
+				Name:    "mqtt-url",
+				Usage:   "mqtt://hostname:port, mqtts:// for TLS or ws:// and wss://hostname/path for WebSockets, required if not part of the config file",
+				EnvVars: []string{"MQTT_HOSTS"},
 			},
 			&cli.StringFlag{
 				Name:    "mqtt-user",
@@ -75,11 +111,88 @@ func main() {
 				Usage:   "mqtt password",
 				EnvVars: []string{"MQTT_PASSWORD"},
 			},
+			&cli.StringFlag{
+				Name:    "mqtt-tls-ca",
+				Usage:   "CA file to verify the broker of mqtts:// URLs, default the system roots",
+				EnvVars: []string{"MQTT_TLS_CA"},
+			},
+			&cli.StringFlag{
+				Name:    "mqtt-tls-cert",
+				Usage:   "client certificate file for mutual TLS",
+				EnvVars: []string{"MQTT_TLS_CERT"},
+			},
+			&cli.StringFlag{
+				Name:    "mqtt-tls-key",
+				Usage:   "client key file for mutual TLS",
+				EnvVars: []string{"MQTT_TLS_KEY"},
+			},
+			&cli.StringFlag{
+				Name:    "mqtt-tls-server-name",
+				Usage:   "server name for SNI and the verification of the broker certificate, default the host of the URL",
+				EnvVars: []string{"MQTT_TLS_SERVER_NAME"},
+			},
+			&cli.BoolFlag{
+				Name:    "mqtt-tls-insecure-skip-verify",
+				Usage:   "accept any broker certificate, for testing only",
+				EnvVars: []string{"MQTT_TLS_INSECURE_SKIP_VERIFY"},
+			},
+			&cli.StringSliceFlag{
+				Name:    "mqtt-ws-header",
+				Usage:   "'Name: value' HTTP header of ws:// and wss:// connections, e.g. an Authorization token",
+				EnvVars: []string{"MQTT_WS_HEADERS"},
+			},
+			&cli.StringFlag{
+				Name:    "mqtt-ws-proxy",
+				Usage:   "HTTP proxy URL for ws:// and wss:// connections, default $HTTPS_PROXY or $HTTP_PROXY",
+				EnvVars: []string{"MQTT_WS_PROXY"},
+			},
+			&cli.StringFlag{
+				Name:    "mqtt-client-id",
+				Usage:   "MQTT client ID, default one picked by the broker",
+				EnvVars: []string{"MQTT_CLIENT_ID"},
+			},
+			&cli.DurationFlag{
+				Name:    "mqtt-keepalive",
+				Value:   defaultKeepAlive,
+				Usage:   "interval of the MQTT keepalive pings",
+				EnvVars: []string{"MQTT_KEEPALIVE"},
+			},
+			&cli.BoolFlag{
+				Name:    "mqtt-clean-session",
+				Value:   true,
+				Usage:   "false keeps the session on the broker while disconnected, needs --mqtt-client-id",
+				EnvVars: []string{"MQTT_CLEAN_SESSION"},
+			},
+			&cli.DurationFlag{
+				Name:    "mqtt-connect-timeout",
+				Value:   defaultConnectTimeout,
+				Usage:   "timeout of a connection attempt to the broker",
+				EnvVars: []string{"MQTT_CONNECT_TIMEOUT"},
+			},
+			&cli.IntFlag{
+				Name:    "mqtt-version",
+				Value:   3,
+				Usage:   "MQTT protocol version, 3 for 3.1.1 or 5",
+				EnvVars: []string{"MQTT_VERSION"},
+			},
+			&cli.StringFlag{
+				Name:    "mqtt-share-group",
+				Usage:   "subscribe to $share/<group>/<topic> so the exporters of the group split the messages, MQTT 5 only",
+				EnvVars: []string{"MQTT_SHARE_GROUP"},
+			},
+			&cli.StringSliceFlag{
+				Name:  "mqtt-user-property",
+				Usage: "key=value user property sent to the broker, MQTT 5 only",
+			},
+			&cli.DurationFlag{
+				Name:    "mqtt-session-expiry",
+				Usage:   "keep the session on the broker this long after a disconnect, MQTT 5 only",
+				EnvVars: []string{"MQTT_SESSION_EXPIRY"},
+			},
 			&cli.StringSliceFlag{
 				Name:    "topic",
 				Aliases: []string{"t"},
-				Value:   cli.NewStringSlice("shellies/+/info"), // "shellies/+/online"
-				Usage:   "MQTT Topics http://www.steves-internet-guide.com/understanding-mqtt-topics/",
+				Usage:   "MQTT topic filter with an optional @qos, e.g. shellies/+/info@1, overrides the topics of the enabled collectors http://www.steves-internet-guide.com/understanding-mqtt-topics/",
 			},
 			&cli.BoolFlag{
 				Name:  "verbose",
@@ -93,47 +206,214 @@ func main() {
 	}
 }
 
-func newMQTTClient(c *cli.Context) (mqtt.Client, func(), error) {
-	o := mqtt.NewClientOptions()
-	for _, mqttUrl := range c.StringSlice("mqtt-url") {
-		u, err := url.Parse(mqttUrl)
-		if err != nil {
-			return nil, nil, fmt.Errorf("failed tp parse URL: %q with: %w", mqttUrl, err)
+// collectorFlags returns a --collector.<name> flag for each registered device
+// collector.
+func collectorFlags() []cli.Flag {
+	var flags []cli.Flag
+	for _, def := range collector.Definitions() {
+		flags = append(flags, &cli.BoolFlag{
+			Name:  collectorFlagName(def),
+			Value: def.Default,
+			Usage: "enable the collector for " + def.Help,
+		})
+		if def.Requests {
+			flags = append(flags, &cli.BoolFlag{
+				Name:  collectorFlagName(def) + ".requests",
+				Usage: "let the " + def.Name + " collector publish requests to the devices, needs publish rights",
+			}, &cli.DurationFlag{
+				Name:  collectorFlagName(def) + ".request-interval",
+				Value: time.Hour,
+				Usage: "minimum time between two requests of the " + def.Name + " collector to the same device",
+			})
 		}
-		o.Servers = append(o.Servers, u)
 	}
-	o.Username = c.String("mqtt-user")
-	o.Password = c.String("mqtt-pass")
-	o.AutoReconnect = true
+	return flags
+}
 
-	mqc := mqtt.NewClient(o)
+func collectorFlagName(def collector.Definition) string {
+	return "collector." + def.Name
+}
 
-	tk := mqc.Connect()
-	<-tk.Done()
-	if err := tk.Error(); err != nil {
-		return nil, nil, fmt.Errorf("failed tp connect: %w", err)
+// loadConfig merges the config file with the flags. Flags set on the command
+// line win, empty fields of the file fall back to the flag defaults.
+func loadConfig(c *cli.Context) (*config.Config, error) {
+	cfg := &config.Config{}
+	if path := c.String("config.file"); path != "" {
+		var err error
+		if cfg, err = config.Load(path); err != nil {
+			return nil, err
+		}
+	}
+	useFlag := func(name string, empty bool) bool {
+		return c.IsSet(name) || empty
 	}
 
-	return mqc, func() {
-		mqc.Disconnect(100)
-	}, nil
+	if useFlag("mqtt-url", len(cfg.MQTT.URLs) == 0) {
+		cfg.MQTT.URLs = c.StringSlice("mqtt-url")
+	}
+	if useFlag("mqtt-user", cfg.MQTT.Username == "") {
+		cfg.MQTT.Username = c.String("mqtt-user")
+	}
+	if useFlag("mqtt-pass", cfg.MQTT.Password == "") {
+		cfg.MQTT.Password = c.String("mqtt-pass")
+	}
+	if useFlag("mqtt-ws-header", len(cfg.MQTT.WebSocket.Headers) == 0) {
+		for _, h := range c.StringSlice("mqtt-ws-header") {
+			name, value, ok := strings.Cut(h, ":")
+			if !ok {
+				return nil, fmt.Errorf("--mqtt-ws-header %q must look like 'Name: value'", h)
+			}
+			if cfg.MQTT.WebSocket.Headers == nil {
+				cfg.MQTT.WebSocket.Headers = make(map[string]string)
+			}
+			cfg.MQTT.WebSocket.Headers[strings.TrimSpace(name)] = strings.TrimSpace(value)
+		}
+	}
+	if useFlag("mqtt-ws-proxy", cfg.MQTT.WebSocket.Proxy == "") {
+		cfg.MQTT.WebSocket.Proxy = c.String("mqtt-ws-proxy")
+	}
+	if useFlag("mqtt-client-id", cfg.MQTT.ClientID == "") {
+		cfg.MQTT.ClientID = c.String("mqtt-client-id")
+	}
+	if useFlag("mqtt-keepalive", cfg.MQTT.KeepAlive == 0) {
+		cfg.MQTT.KeepAlive = c.Duration("mqtt-keepalive")
+	}
+	if useFlag("mqtt-clean-session", cfg.MQTT.CleanSession == nil) {
+		clean := c.Bool("mqtt-clean-session")
+		cfg.MQTT.CleanSession = &clean
+	}
+	if useFlag("mqtt-connect-timeout", cfg.MQTT.ConnectTimeout == 0) {
+		cfg.MQTT.ConnectTimeout = c.Duration("mqtt-connect-timeout")
+	}
+	if useFlag("mqtt-version", cfg.MQTT.Version == 0) {
+		cfg.MQTT.Version = c.Int("mqtt-version")
+	}
+	if useFlag("mqtt-share-group", cfg.MQTT.ShareGroup == "") {
+		cfg.MQTT.ShareGroup = c.String("mqtt-share-group")
+	}
+	if useFlag("mqtt-user-property", len(cfg.MQTT.UserProperties) == 0) {
+		for _, kv := range c.StringSlice("mqtt-user-property") {
+			k, v, ok := strings.Cut(kv, "=")
+			if !ok {
+				return nil, fmt.Errorf("--mqtt-user-property %q must look like key=value", kv)
+			}
+			if cfg.MQTT.UserProperties == nil {
+				cfg.MQTT.UserProperties = make(map[string]string)
+			}
+			cfg.MQTT.UserProperties[k] = v
+		}
+	}
+	if useFlag("mqtt-session-expiry", cfg.MQTT.SessionExpiry == 0) {
+		cfg.MQTT.SessionExpiry = c.Duration("mqtt-session-expiry")
+	}
+	if useFlag("mqtt-tls-ca", cfg.MQTT.TLS.CAFile == "") {
+		cfg.MQTT.TLS.CAFile = c.String("mqtt-tls-ca")
+	}
+	if useFlag("mqtt-tls-cert", cfg.MQTT.TLS.CertFile == "") {
+		cfg.MQTT.TLS.CertFile = c.String("mqtt-tls-cert")
+	}
+	if useFlag("mqtt-tls-key", cfg.MQTT.TLS.KeyFile == "") {
+		cfg.MQTT.TLS.KeyFile = c.String("mqtt-tls-key")
+	}
+	if useFlag("mqtt-tls-server-name", cfg.MQTT.TLS.ServerName == "") {
+		cfg.MQTT.TLS.ServerName = c.String("mqtt-tls-server-name")
+	}
+	if useFlag("mqtt-tls-insecure-skip-verify", !cfg.MQTT.TLS.InsecureSkipVerify) {
+		cfg.MQTT.TLS.InsecureSkipVerify = c.Bool("mqtt-tls-insecure-skip-verify")
+	}
+	if useFlag("topic", len(cfg.Subscriptions) == 0) {
+		cfg.Subscriptions = c.StringSlice("topic")
+	}
+	if useFlag("http-listen-address", cfg.Output.ListenAddress == "") {
+		cfg.Output.ListenAddress = c.String("http-listen-address")
+	}
+	if useFlag("http-path-metrics", cfg.Output.MetricsPath == "") {
+		cfg.Output.MetricsPath = c.String("http-path-metrics")
+	}
+	if useFlag("enable-exporter-metrics", cfg.Output.ExporterMetrics == nil) {
+		v := c.Bool("enable-exporter-metrics")
+		cfg.Output.ExporterMetrics = &v
+	}
+	if useFlag("device-timestamps", cfg.Output.DeviceTimestamps == nil) {
+		v := c.Bool("device-timestamps")
+		cfg.Output.DeviceTimestamps = &v
+	}
+	if useFlag("queue-size", cfg.Output.QueueSize == nil) {
+		v := c.Int("queue-size")
+		cfg.Output.QueueSize = &v
+	}
+
+	if cfg.Collectors == nil {
+		cfg.Collectors = make(map[string]config.Collector)
+	}
+	for _, def := range collector.Definitions() {
+		cc := cfg.Collectors[def.Name]
+		if useFlag(collectorFlagName(def), cc.Enabled == nil) {
+			v := def.Default
+			if c.IsSet(collectorFlagName(def)) {
+				v = c.Bool(collectorFlagName(def))
+			}
+			cc.Enabled = &v
+		}
+		if def.Requests {
+			if useFlag(collectorFlagName(def)+".requests", cc.Requests == nil) {
+				v := c.Bool(collectorFlagName(def) + ".requests")
+				cc.Requests = &v
+			}
+			if useFlag(collectorFlagName(def)+".request-interval", cc.RequestInterval == 0) {
+				cc.RequestInterval = c.Duration(collectorFlagName(def) + ".request-interval")
+			}
+		}
+		cfg.Collectors[def.Name] = cc
+	}
+	if len(cfg.Subscriptions) == 0 {
+		cfg.Subscriptions = collectorSubscriptions(cfg)
+	}
+
+	if path := c.String("device-map"); path != "" {
+		devices, err := devicemap.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Devices = append(cfg.Devices, devices...)
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	if len(cfg.MQTT.URLs) == 0 {
+		return nil, errors.New("at least one MQTT URL is required, set --mqtt-url or mqtt.urls in the config file")
+	}
+	return cfg, nil
 }
 
 func actionDebug(c *cli.Context) error {
-	mqc, cancel, err := newMQTTClient(c)
+	cfg, err := loadConfig(c)
+	if err != nil {
+		return err
+	}
+	zaplog, err := zap.NewProduction()
+	if err != nil {
+		return err
+	}
+	defer zaplog.Sync()
+	// logs the connection attempts while the broker is unreachable
+	mqc, cancel, err := newMQTTClient(cfg, newMQTTStats(), zaplog)
 	if err != nil {
 		return err
 	}
 	defer cancel()
 
-	for _, topic := range c.StringSlice("topic") {
-		tk := mqc.Subscribe(topic, 0, func(client mqtt.Client, message mqtt.Message) {
+	for _, topic := range cfg.Subscriptions {
+		sub, err := config.ParseSubscription(topic)
+		if err != nil {
+			return err
+		}
+		err = mqc.Subscribe(sub.Topic, sub.QoS, func(message mqtt.Message) {
 			t := time.Now().Format("2006-01-02T15:04:05.999")
 			fmt.Printf("%s::: message topic:: %s=%s\n", t, message.Topic(), string(message.Payload()))
-			message.Ack()
 		})
-		<-tk.Done()
-		if err := tk.Error(); err != nil {
+		if err != nil {
 			return err
 		} else {
 			fmt.Println("subscribed to:", topic)
@@ -148,12 +428,10 @@ func actionDebug(c *cli.Context) error {
 }
 
 func actionProm(c *cli.Context) error {
-	mqc, cancel, err := newMQTTClient(c)
+	cfg, err := loadConfig(c)
 	if err != nil {
 		return err
 	}
-	defer cancel()
-
 	zapencCfg := zap.NewProductionEncoderConfig()
 	zapencCfg.EncodeTime = zapcore.RFC3339NanoTimeEncoder
 
@@ -168,32 +446,64 @@ func actionProm(c *cli.Context) error {
 	))
 	defer zaplog.Sync()
 
-	messageChanHT := make(chan mqtt.Message)
-	messageChanHTGen3 := make(chan mqtt.Message)
-	messageChan3EM := make(chan mqtt.Message)
-	go subscribe(c, mqc, zaplog, messageChanHT, messageChanHTGen3, messageChan3EM)
-	defer mqc.Unsubscribe(c.StringSlice("topic")...)
-	defer func() {
-		close(messageChanHT)
-		close(messageChanHTGen3)
-		close(messageChan3EM)
-	}()
+	if cfg.MQTT.TLS.InsecureSkipVerify {
+		zaplog.Warn("TLS certificate of the broker is not verified")
+	}
+	checkSubscriptions(cfg, zaplog)
+	// connects in the background so /metrics answers with
+	// shelly_mqtt_connected 0 while the broker is unreachable
+	mqttStats := newMQTTStats()
+	mqc, cancel, err := newMQTTClient(cfg, mqttStats, zaplog)
+	if err != nil {
+		return err
+	}
+	defer cancel()
 
+	router := collector.NewRouter(zaplog, *cfg.Output.QueueSize)
+	stats := collector.NewStats()
+	presence := collector.NewPresence()
 	reg := prometheus.NewPedanticRegistry()
-	reg.MustRegister(ht.NewCollector(c.Context, messageChanHT, ht.Options{
-		Timeout: 60 * time.Second,
-		Log:     zaplog,
-	}))
-	reg.MustRegister(htgen3.NewCollector(c.Context, messageChanHTGen3, htgen3.Options{
-		Timeout: 60 * time.Second,
-		Log:     zaplog,
-	}))
-	reg.MustRegister(threeem.NewCollector(c.Context, messageChan3EM, threeem.Options{
-		Timeout: 60 * time.Second,
-		Log:     zaplog,
-	}))
-
-	if c.Bool("enable-exporter-metrics") {
+	reg.MustRegister(router, stats, mqttStats)
+	for _, def := range collector.Definitions() {
+		cc := cfg.Collectors[def.Name]
+		if !*cc.Enabled {
+			zaplog.Info("collector disabled", zap.String("collector", def.Name))
+			continue
+		}
+		// one per collector, a device seen by two collectors has a last
+		// reading in each of them
+		var timestamps *collector.Timestamps
+		if *cfg.Output.DeviceTimestamps {
+			timestamps = collector.NewTimestamps()
+		}
+		opts := collector.Options{
+			Name:            def.Name,
+			StaleAfter:      cc.StaleAfter,
+			Log:             zaplog,
+			RequestInterval: cc.RequestInterval,
+			Presence:        presence,
+			Timestamps:      timestamps,
+			Stats:           stats,
+		}
+		if cc.Requests != nil && *cc.Requests {
+			opts.Publish = func(topic string, payload []byte) {
+				mqc.Publish(topic, 0, payload)
+			}
+		}
+		col := def.New(c.Context, router.Add(def), opts)
+		if timestamps != nil {
+			col = collector.WithTimestamps(col, timestamps)
+		}
+		reg.MustRegister(col)
+	}
+	subs := &subscriptions{mqc: mqc, router: router, log: zaplog, stats: mqttStats}
+	go subs.Set(cfg.Subscriptions)
+	// deferred in reverse: stop the deliveries of the broker first, then close
+	// the queues. Route discards messages still in flight after Close.
+	defer router.Close()
+	defer subs.Close()
+
+	if *cfg.Output.ExporterMetrics {
 		reg.MustRegister(
 			collectors.NewBuildInfoCollector(),
 			collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
@@ -202,13 +512,22 @@ func actionProm(c *cli.Context) error {
 		)
 	}
 
-	http.Handle(c.String("http-path-metrics"), promhttp.HandlerFor(reg, promhttp.HandlerOpts{}))
+	var labeler devicemap.Labeler
+	m, err := devicemap.New(cfg.Devices)
+	if err != nil {
+		return err
+	}
+	labeler.Set(m)
+	go reload(c, zaplog, cfg, subs, &labeler)
+
+	metricsPath := cfg.Output.MetricsPath
+	http.Handle(metricsPath, promhttp.HandlerFor(labeler.Gatherer(reg), promhttp.HandlerOpts{}))
 	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		w.Write([]byte(`<html>
 			<head><title>Shelly Exporter</title></head>
 			<body>
 			<h1>Shelly Exporter</h1>
-			<p><a href="` + c.String("http-path-metrics") + `">Metrics</a></p>
+			<p><a href="` + metricsPath + `">Metrics</a></p>
 			</body>
 			</html>`))
 	})
@@ -216,15 +535,15 @@ func actionProm(c *cli.Context) error {
 	server := &http.Server{}
 
 	zaplog.Info("http config",
-		zap.String("path", c.String("http-path-metrics")),
-		zap.String("listen_address", c.String("http-listen-address")),
+		zap.String("path", metricsPath),
+		zap.String("listen_address", cfg.Output.ListenAddress),
 	)
 
 	slogLogWrap := slog.New(slogzap.Option{Level: slog.LevelDebug, Logger: zaplog}.NewZapHandler())
 
 	var empty string
 	if err := web.ListenAndServe(server, &web.FlagConfig{
-		WebListenAddresses: &[]string{c.String("http-listen-address")},
+		WebListenAddresses: &[]string{cfg.Output.ListenAddress},
 		WebSystemdSocket:   nil,
 		WebConfigFile:      &empty,
 	}, slogLogWrap); err != nil {
@@ -235,20 +554,39 @@ func actionProm(c *cli.Context) error {
 	return nil
 }
 
-func subscribe(c *cli.Context, mqc mqtt.Client, log *zap.Logger, messageChans ...chan<- mqtt.Message) {
-	log.Info("subscribing to", zap.Strings("topics", c.StringSlice("topic")))
-	for _, topic := range c.StringSlice("topic") {
-		tk := mqc.Subscribe(topic, 0, func(client mqtt.Client, message mqtt.Message) {
-			for _, messageChan := range messageChans {
-				messageChan <- message
+// reload applies the subscriptions and devices of the config file and the
+// device map again on SIGHUP. A broken file keeps the previous settings. The
+// brokers, collectors and output only change with a restart.
+func reload(c *cli.Context, log *zap.Logger, cfg *config.Config, subs *subscriptions, labeler *devicemap.Labeler) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	for {
+		select {
+		case <-hup:
+			newCfg, err := loadConfig(c)
+			if err != nil {
+				log.Error("reloading config failed", zap.Error(err))
+				continue
 			}
-			message.Ack()
-		})
-		<-tk.Done()
-		if err := tk.Error(); err != nil {
-			log.Error("subscribe failed", zap.Error(err), zap.String("topic", topic))
-		} else {
-			log.Info("subscribed to", zap.String("topic", topic))
+			m, err := devicemap.New(newCfg.Devices)
+			if err != nil {
+				log.Error("reloading devices failed", zap.Error(err))
+				continue
+			}
+			labeler.Set(m)
+			subs.Set(newCfg.Subscriptions)
+			checkSubscriptions(newCfg, log)
+
+			if !reflect.DeepEqual(cfg.MQTT, newCfg.MQTT) ||
+				!reflect.DeepEqual(cfg.Collectors, newCfg.Collectors) ||
+				!reflect.DeepEqual(cfg.Output, newCfg.Output) {
+				log.Warn("changes of mqtt, collectors and output need a restart")
+			}
+			log.Info("config reloaded", zap.Strings("topics", newCfg.Subscriptions), zap.Int("devices", len(newCfg.Devices)))
+		case <-c.Done():
+			return
 		}
 	}
 }
@@ -0,0 +1,257 @@
+package motion
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/SchumacherFM/prometheus_shelly_exporter/bthome"
+	"github.com/SchumacherFM/prometheus_shelly_exporter/collector"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/tidwall/gjson"
+	"go.uber.org/zap"
+)
+
+// Shelly Motion (Gen1) publishes its state as JSON on
+// shellies/shellymotionsensor-<id>/status:
+//
+//	{"motion":true,"timestamp":1707641000,"active":true,"vibration":false,"lux":52,"bat":98}
+//
+// Shelly BLU Motion sensors are relayed by a Gen2/Gen3 device running the BLE
+// gateway script which emits a NotifyEvent on <prefix>/events/rpc with the
+// BTHome advertisement, see bthome.FromEventData.
+
+type device struct {
+	motion       bool
+	events       float64
+	lastEventKey int64 // timestamp (Gen1) or packet ID (BLU) of the last counted motion
+	lux          float64
+	hasLux       bool
+	battery      float64
+	hasBattery   bool
+	vibration    bool
+	active       bool
+	isGen1       bool
+}
+
+type Collector struct {
+	opts          Options
+	motionDesc    *prometheus.Desc
+	eventsDesc    *prometheus.Desc
+	luxDesc       *prometheus.Desc
+	batDesc       *prometheus.Desc
+	vibrationDesc *prometheus.Desc
+	activeDesc    *prometheus.Desc
+	health        *collector.Health
+
+	mu      sync.Mutex
+	devices map[string]*device // device ID or BLU MAC => state
+}
+
+type Options struct {
+	collector.Options
+	TestCB func()
+}
+
+func init() {
+	collector.Register(collector.Definition{
+		Name:          "motion",
+		Help:          "Shelly Motion and BLU Motion",
+		Default:       true,
+		Subscriptions: []string{"shellies/+/status", "+/events/rpc"},
+		New: func(ctx context.Context, messageChan <-chan mqtt.Message, opts collector.Options) prometheus.Collector {
+			return NewCollector(ctx, messageChan, Options{Options: opts})
+		},
+	})
+}
+
+func NewCollector(ctx context.Context, messageChan <-chan mqtt.Message, opts Options) *Collector {
+	c := &Collector{
+		opts:          opts,
+		motionDesc:    prometheus.NewDesc("shellymotion_motion", "1 if motion is currently detected", []string{"device"}, nil),
+		eventsDesc:    prometheus.NewDesc("shellymotion_motion_events_total", "number of detected motion events since the exporter started", []string{"device"}, nil),
+		luxDesc:       prometheus.NewDesc("shellymotion_lux", "illuminance in lux", []string{"device"}, nil),
+		batDesc:       prometheus.NewDesc("shellymotion_battery", "Sensor battery", []string{"device", "unit"}, nil),
+		vibrationDesc: prometheus.NewDesc("shellymotion_vibration", "1 if the sensor detected vibration (tamper)", []string{"device"}, nil),
+		activeDesc:    prometheus.NewDesc("shellymotion_active", "1 if motion detection is enabled", []string{"device"}, nil),
+		health:        collector.NewHealth("shellymotion", opts.Options),
+		devices:       make(map[string]*device),
+	}
+
+	go func() {
+		for {
+			select {
+			case msg, ok := <-messageChan:
+				if !ok {
+					if opts.TestCB != nil {
+						opts.TestCB()
+					}
+					return
+				}
+
+				switch {
+				case strings.HasPrefix(msg.Topic(), "shellies/") && strings.HasSuffix(msg.Topic(), "/status"):
+					c.handleGen1(msg.Topic(), msg.Payload())
+				case strings.HasSuffix(msg.Topic(), "/rpc"):
+					c.handleBLU(msg.Topic(), msg.Payload())
+				default:
+					continue
+				}
+				opts.Log.Debug("message from mqtt",
+					zap.String("topic", msg.Topic()),
+					zap.Int("length", len(msg.Payload())))
+
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return c
+}
+
+// device returns the state for the device ID and creates it if needed. The
+// caller must hold c.mu.
+func (c *Collector) device(devID string) *device {
+	d, ok := c.devices[devID]
+	if !ok {
+		d = &device{lastEventKey: -1}
+		c.devices[devID] = d
+	}
+	return d
+}
+
+// setMotion stores the motion state and counts a new event if eventKey has
+// not been seen yet. Devices repeat their last state, so the key tells a new
+// event from a retransmitted one. A negative eventKey only counts a change to
+// motion.
+func (d *device) setMotion(motion bool, eventKey int64) {
+	isNew := eventKey != d.lastEventKey
+	if eventKey < 0 {
+		isNew = !d.motion
+	}
+	if motion && isNew {
+		d.events++
+		d.lastEventKey = eventKey
+	}
+	d.motion = motion
+}
+
+func (c *Collector) handleGen1(topic string, payload []byte) {
+	r := gjson.ParseBytes(payload)
+	if !r.Get("motion").Exists() {
+		return
+	}
+	// shellies/shellymotionsensor-<id>/status
+	topicPaths := strings.Split(topic, "/")
+	if len(topicPaths) != 3 {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	d := c.device(topicPaths[1])
+	d.isGen1 = true
+	// timestamp is the time of the last motion, not of the status
+	c.opts.Timestamps.Observe(topicPaths[1], 0)
+	c.health.OK(topicPaths[1])
+	d.setMotion(r.Get("motion").Bool(), r.Get("timestamp").Int())
+	d.vibration = r.Get("vibration").Bool()
+	d.active = r.Get("active").Bool()
+	if v := r.Get("lux"); v.Exists() {
+		d.lux, d.hasLux = v.Float(), true
+	}
+	if v := r.Get("bat"); v.Exists() {
+		d.battery, d.hasBattery = v.Float(), true
+	}
+}
+
+func (c *Collector) handleBLU(topic string, payload []byte) {
+	r := gjson.ParseBytes(payload)
+	if r.Get("method").String() != "NotifyEvent" {
+		return
+	}
+
+	ts := r.Get("params.ts").Float()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	r.Get("params.events").ForEach(func(_, event gjson.Result) bool {
+		adv, ok, err := bthome.FromEventData(event.Get("data"))
+		switch {
+		case !ok:
+			return true
+		case err != nil:
+			c.health.ParseError(adv.Address, topic, fmt.Errorf("advertisement of %s: %w", adv.Address, err))
+			return true
+		}
+		motion, ok := adv.Packet.Value("motion")
+		if !ok {
+			return true
+		}
+		pid, hasPID := adv.Packet.Value("packet_id")
+		if !hasPID {
+			pid = -1
+		}
+		d := c.device(adv.Address)
+		c.opts.Timestamps.Observe(adv.Address, ts)
+		c.health.OK(adv.Address)
+		d.setMotion(motion == 1, int64(pid))
+		if v, ok := adv.Packet.Value("illuminance"); ok {
+			d.lux, d.hasLux = v, true
+		}
+		if v, ok := adv.Packet.Value("battery"); ok {
+			d.battery, d.hasBattery = v, true
+		}
+		return true
+	})
+}
+
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.motionDesc
+	ch <- c.eventsDesc
+	ch <- c.luxDesc
+	ch <- c.batDesc
+	ch <- c.vibrationDesc
+	ch <- c.activeDesc
+	c.health.Describe(ch)
+}
+
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	c.collect(ch)
+	c.health.Collect(ch)
+}
+
+func boolToFloat(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func (c *Collector) collect(ch chan<- prometheus.Metric) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for devID, d := range c.devices {
+		if c.opts.Presence.Offline(devID) {
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(c.motionDesc, prometheus.GaugeValue, boolToFloat(d.motion), devID)
+		ch <- prometheus.MustNewConstMetric(c.eventsDesc, prometheus.CounterValue, d.events, devID)
+		if d.hasLux {
+			ch <- prometheus.MustNewConstMetric(c.luxDesc, prometheus.GaugeValue, d.lux, devID)
+		}
+		if d.hasBattery {
+			ch <- prometheus.MustNewConstMetric(c.batDesc, prometheus.GaugeValue, d.battery, devID, "%")
+		}
+		if d.isGen1 {
+			ch <- prometheus.MustNewConstMetric(c.vibrationDesc, prometheus.GaugeValue, boolToFloat(d.vibration), devID)
+			ch <- prometheus.MustNewConstMetric(c.activeDesc, prometheus.GaugeValue, boolToFloat(d.active), devID)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package motion
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/SchumacherFM/prometheus_shelly_exporter/collector"
+	"github.com/SchumacherFM/prometheus_shelly_exporter/collector/collectortest"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+var _ prometheus.Collector = (*Collector)(nil)
+
+func TestCollector_collect(t *testing.T) {
+	ctx := context.Background()
+	msgChan := make(chan mqtt.Message)
+
+	log, _ := zap.NewDevelopment(zap.Development())
+	msgGoRoutineDone := make(chan struct{})
+	c := NewCollector(ctx, msgChan, Options{
+		Options: collector.Options{Log: log},
+		TestCB: func() {
+			close(msgGoRoutineDone)
+		},
+	})
+
+	collectortest.SendFile(t, msgChan, "testdata/motion.txt")
+	close(msgChan)
+	<-msgGoRoutineDone
+
+	err := testutil.CollectAndCompare(c, strings.NewReader(`
+# HELP shellymotion_active 1 if motion detection is enabled
+# TYPE shellymotion_active gauge
+shellymotion_active{device="shellymotionsensor-60A423B1C2D3"} 1
+# HELP shellymotion_battery Sensor battery
+# TYPE shellymotion_battery gauge
+shellymotion_battery{device="0b:ae:5f:33:9b:3c",unit="%"} 99
+shellymotion_battery{device="shellymotionsensor-60A423B1C2D3",unit="%"} 97
+# HELP shellymotion_lux illuminance in lux
+# TYPE shellymotion_lux gauge
+shellymotion_lux{device="0b:ae:5f:33:9b:3c"} 105
+shellymotion_lux{device="shellymotionsensor-60A423B1C2D3"} 45
+# HELP shellymotion_motion 1 if motion is currently detected
+# TYPE shellymotion_motion gauge
+shellymotion_motion{device="0b:ae:5f:33:9b:3c"} 1
+shellymotion_motion{device="shellymotionsensor-60A423B1C2D3"} 1
+# HELP shellymotion_motion_events_total number of detected motion events since the exporter started
+# TYPE shellymotion_motion_events_total counter
+shellymotion_motion_events_total{device="0b:ae:5f:33:9b:3c"} 2
+shellymotion_motion_events_total{device="shellymotionsensor-60A423B1C2D3"} 2
+# HELP shellymotion_up 1 if the last reading of the device was fine
+# TYPE shellymotion_up gauge
+shellymotion_up{device="0b:ae:5f:33:9b:3c"} 1
+shellymotion_up{device="shellymotionsensor-60A423B1C2D3"} 1
+# HELP shellymotion_vibration 1 if the sensor detected vibration (tamper)
+# TYPE shellymotion_vibration gauge
+shellymotion_vibration{device="shellymotionsensor-60A423B1C2D3"} 1
+`),
+		"shellymotion_active",
+		"shellymotion_battery",
+		"shellymotion_lux",
+		"shellymotion_motion",
+		"shellymotion_motion_events_total",
+		"shellymotion_up",
+		"shellymotion_vibration",
+	)
+	require.NoError(t, err)
+}
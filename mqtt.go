@@ -0,0 +1,397 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"maps"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/SchumacherFM/prometheus_shelly_exporter/collector"
+	"github.com/SchumacherFM/prometheus_shelly_exporter/config"
+	"github.com/eclipse/paho.mqtt.golang"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+// mqttStats are the exporter's own metrics of the MQTT client.
+type mqttStats struct {
+	connected         prometheus.Gauge
+	reconnects        prometheus.Counter
+	subscribeFailures *prometheus.CounterVec
+}
+
+func newMQTTStats() *mqttStats {
+	return &mqttStats{
+		connected: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "shelly_mqtt_connected",
+			Help: "1 if the exporter is connected to the broker",
+		}),
+		reconnects: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "shelly_mqtt_reconnects_total",
+			Help: "reconnect attempts after the connection to the broker got lost",
+		}),
+		subscribeFailures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "shelly_mqtt_subscription_failures_total",
+			Help: "failed subscriptions per topic filter",
+		}, []string{"topic"}),
+	}
+}
+
+func (s *mqttStats) Describe(ch chan<- *prometheus.Desc) {
+	s.connected.Describe(ch)
+	s.reconnects.Describe(ch)
+	s.subscribeFailures.Describe(ch)
+}
+
+func (s *mqttStats) Collect(ch chan<- prometheus.Metric) {
+	s.connected.Collect(ch)
+	s.reconnects.Collect(ch)
+	s.subscribeFailures.Collect(ch)
+}
+
+// opTimeout bounds subscribe, unsubscribe and publish calls.
+const opTimeout = 10 * time.Second
+
+// subackFailure is the granted QoS of a rejected subscription, MQTT 5 reason
+// codes from 0x80 on are failures as well.
+const subackFailure = 0x80
+
+// errRejected is a subscription the broker refused, e.g. for a missing ACL.
+// The clients forget it while other errors keep it for the next connect.
+var errRejected = errors.New("rejected by the broker")
+
+// Connection attempts wait with an exponential backoff from minBackoff up to
+// maxBackoff. The jitter of up to half the delay keeps exporters from
+// reconnecting in lockstep after a broker restart.
+const (
+	minBackoff = time.Second
+	maxBackoff = 2 * time.Minute
+)
+
+// backoff returns the delay before the attempt, 0 for the first one.
+func backoff(attempt int) time.Duration {
+	if attempt <= 0 {
+		return 0
+	}
+	d := min(minBackoff<<min(attempt-1, 16), maxBackoff)
+	return d/2 + rand.N(d/2+1)
+}
+
+// Defaults of mqtt.keepalive and mqtt.connect_timeout, the same as paho.
+const (
+	defaultKeepAlive      = 30 * time.Second
+	defaultConnectTimeout = 30 * time.Second
+)
+
+// subscription is a topic filter of the client. Both clients subscribe to all
+// of them on each connect, after a broker restart or without a persistent
+// session the broker has forgotten them.
+type subscription struct {
+	qos     byte
+	handler func(mqtt.Message)
+}
+
+// resubscribe sends subs again with the subscribe function of a client.
+func resubscribe(subs map[string]subscription, subscribe func(string, subscription) error, stats *mqttStats, log *zap.Logger) {
+	if len(subs) == 0 {
+		return
+	}
+	topics := make([]string, 0, len(subs))
+	for topic, sub := range subs {
+		if err := subscribe(topic, sub); err != nil {
+			log.Error("resubscribe failed", zap.Error(err), zap.String("topic", topic))
+			stats.subscribeFailures.WithLabelValues(topic).Inc()
+			continue
+		}
+		topics = append(topics, topic)
+	}
+	log.Info("subscribed after connect", zap.Strings("topics", topics))
+}
+
+// mqttClient is the part of an MQTT client the exporter uses. It is paho for
+// MQTT 3.1.1 or paho.golang for MQTT 5, see --mqtt-version. Messages of both
+// are an mqtt.Message for the collectors.
+type mqttClient interface {
+	// Subscribe adds the topic filter, handler gets its messages. While
+	// disconnected the client subscribes on connect and returns nil.
+	Subscribe(topic string, qos byte, handler func(mqtt.Message)) error
+	Unsubscribe(topics ...string) error
+	// Publish sends the payload without waiting for the broker.
+	Publish(topic string, qos byte, payload []byte)
+	Disconnect()
+}
+
+// newMQTTClient returns the client without waiting for the broker, it
+// connects and reconnects in the background with backoff. The returned func
+// disconnects.
+func newMQTTClient(cfg *config.Config, stats *mqttStats, log *zap.Logger) (mqttClient, func(), error) {
+	tr, err := newTransport(cfg.MQTT)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	mc := cfg.MQTT
+	if mc.KeepAlive == 0 {
+		mc.KeepAlive = defaultKeepAlive
+	}
+	if mc.ConnectTimeout == 0 {
+		mc.ConnectTimeout = defaultConnectTimeout
+	}
+
+	var mqc mqttClient
+	if mc.Version == 5 {
+		mqc, err = newMQTT5Client(mc, tr, stats, log)
+		if err != nil {
+			return nil, nil, err
+		}
+	} else {
+		mqc = newMQTT3Client(mc, tr, stats, log)
+	}
+
+	return mqc, func() {
+		mqc.Disconnect()
+		stats.connected.Set(0)
+	}, nil
+}
+
+// transport is how the clients reach the brokers.
+type transport struct {
+	servers []*url.URL
+	tls     *tls.Config
+	// header and proxy of the HTTP upgrade request of ws:// and wss:// URLs
+	header http.Header
+	proxy  func(*http.Request) (*url.URL, error)
+}
+
+func newTransport(cfg config.MQTT) (transport, error) {
+	var tr transport
+	for _, mqttUrl := range cfg.URLs {
+		u, err := url.Parse(mqttUrl)
+		if err != nil {
+			return tr, fmt.Errorf("failed tp parse URL: %q with: %w", mqttUrl, err)
+		}
+		tr.servers = append(tr.servers, u)
+	}
+	var err error
+	if tr.tls, err = newTLSConfig(cfg.TLS); err != nil {
+		return tr, err
+	}
+
+	tr.header = make(http.Header)
+	for name, value := range cfg.WebSocket.Headers {
+		tr.header.Set(name, value)
+	}
+	tr.proxy = http.ProxyFromEnvironment
+	if cfg.WebSocket.Proxy != "" {
+		u, err := url.Parse(cfg.WebSocket.Proxy)
+		if err != nil {
+			return tr, fmt.Errorf("failed to parse proxy URL: %w", err)
+		}
+		tr.proxy = http.ProxyURL(u)
+	}
+	return tr, nil
+}
+
+// mqtt3Client is the paho MQTT 3.1.1 client. It connects and reconnects on
+// its own with backoff as the reconnect of paho has no jitter.
+type mqtt3Client struct {
+	c     mqtt.Client
+	stats *mqttStats
+	log   *zap.Logger
+
+	mu     sync.Mutex
+	subs   map[string]subscription
+	closed bool
+	done   chan struct{} // closed by Disconnect
+}
+
+func newMQTT3Client(cfg config.MQTT, tr transport, stats *mqttStats, log *zap.Logger) *mqtt3Client {
+	c := &mqtt3Client{
+		stats: stats,
+		log:   log,
+		subs:  make(map[string]subscription),
+		done:  make(chan struct{}),
+	}
+
+	o := mqtt.NewClientOptions()
+	o.Servers = tr.servers
+	o.ClientID = cfg.ClientID
+	o.Username = cfg.Username
+	o.Password = cfg.Password
+	o.SetTLSConfig(tr.tls)
+	o.HTTPHeaders = tr.header
+	o.WebsocketOptions = &mqtt.WebsocketOptions{Proxy: tr.proxy}
+	o.KeepAlive = int64(cfg.KeepAlive / time.Second)
+	o.CleanSession = !cfg.PersistentSession()
+	o.ConnectTimeout = cfg.ConnectTimeout
+	o.AutoReconnect = false
+	o.DefaultPublishHandler = c.route
+	o.OnConnect = func(mqtt.Client) { // runs in its own goroutine
+		stats.connected.Set(1)
+		log.Info("connected to the broker")
+		c.mu.Lock()
+		subs := maps.Clone(c.subs)
+		c.mu.Unlock()
+		resubscribe(subs, c.subscribe, stats, log)
+	}
+	o.OnConnectionLost = func(_ mqtt.Client, err error) {
+		stats.connected.Set(0)
+		log.Warn("connection to the broker lost", zap.Error(err))
+		c.connect(true)
+	}
+
+	c.c = mqtt.NewClient(o)
+	go c.connect(false)
+	return c
+}
+
+// connect tries to connect until it succeeds or Disconnect gets called.
+func (c *mqtt3Client) connect(reconnect bool) {
+	for attempt := 0; ; attempt++ {
+		select {
+		case <-c.done:
+			return
+		case <-time.After(backoff(attempt)):
+		}
+		if reconnect {
+			c.stats.reconnects.Inc()
+		}
+		tk := c.c.Connect()
+		tk.Wait() // bounded by the connect timeout
+		if err := tk.Error(); err != nil {
+			c.log.Warn("connecting to the broker failed", zap.Error(err), zap.Int("attempt", attempt+1))
+			continue
+		}
+		select {
+		case <-c.done: // Disconnect came first
+			c.c.Disconnect(0)
+		default:
+		}
+		return
+	}
+}
+
+// Subscribe remembers the topic filter and subscribes right away if the client
+// is connected, otherwise on connect.
+func (c *mqtt3Client) Subscribe(topic string, qos byte, handler func(mqtt.Message)) error {
+	sub := subscription{qos: qos, handler: handler}
+	c.mu.Lock()
+	c.subs[topic] = sub
+	c.mu.Unlock()
+
+	if !c.c.IsConnectionOpen() {
+		return nil
+	}
+	err := c.subscribe(topic, sub)
+	if errors.Is(err, errRejected) {
+		c.mu.Lock()
+		delete(c.subs, topic)
+		c.mu.Unlock()
+	}
+	return err
+}
+
+// route hands a message to the handler of the first matching subscription,
+// paho would call the handler of each overlapping subscription and count it
+// twice.
+func (c *mqtt3Client) route(_ mqtt.Client, message mqtt.Message) {
+	var handler func(mqtt.Message)
+	c.mu.Lock()
+	for filter, sub := range c.subs {
+		if collector.TopicMatches(filter, message.Topic()) {
+			handler = sub.handler
+			break
+		}
+	}
+	c.mu.Unlock()
+
+	if handler != nil {
+		handler(message)
+	}
+	message.Ack()
+}
+
+// subscribe sends the SUBSCRIBE, the messages arrive at route.
+func (c *mqtt3Client) subscribe(topic string, sub subscription) error {
+	tk := c.c.Subscribe(topic, sub.qos, nil)
+	if !tk.WaitTimeout(opTimeout) {
+		return errors.New("subscribe timed out")
+	}
+	if err := tk.Error(); err != nil {
+		return err
+	}
+	if st, ok := tk.(*mqtt.SubscribeToken); ok && st.Result()[topic] == subackFailure {
+		return errRejected
+	}
+	return nil
+}
+
+func (c *mqtt3Client) Unsubscribe(topics ...string) error {
+	c.mu.Lock()
+	for _, topic := range topics {
+		delete(c.subs, topic)
+	}
+	c.mu.Unlock()
+
+	if !c.c.IsConnectionOpen() {
+		return nil
+	}
+	tk := c.c.Unsubscribe(topics...)
+	if !tk.WaitTimeout(opTimeout) {
+		return errors.New("unsubscribe timed out")
+	}
+	return tk.Error()
+}
+
+func (c *mqtt3Client) Publish(topic string, qos byte, payload []byte) {
+	c.c.Publish(topic, qos, false, payload)
+}
+
+func (c *mqtt3Client) Disconnect() {
+	c.mu.Lock()
+	if !c.closed {
+		c.closed = true
+		close(c.done)
+	}
+	c.mu.Unlock()
+	if c.c.IsConnectionOpen() {
+		c.c.Disconnect(100)
+	}
+}
+
+// newTLSConfig returns the TLS config of mqtts:// and ssl:// URLs, nil keeps
+// the defaults of paho.
+func newTLSConfig(cfg config.TLS) (*tls.Config, error) {
+	if cfg == (config.TLS{}) {
+		return nil, nil
+	}
+	tc := &tls.Config{
+		ServerName:         cfg.ServerName,
+		InsecureSkipVerify: cfg.InsecureSkipVerify,
+	}
+	if cfg.CAFile != "" {
+		pem, err := os.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA file: %w", err)
+		}
+		tc.RootCAs = x509.NewCertPool()
+		if !tc.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in CA file %q", cfg.CAFile)
+		}
+	}
+	if cfg.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load TLS client certificate: %w", err)
+		}
+		tc.Certificates = []tls.Certificate{cert}
+	}
+	return tc, nil
+}
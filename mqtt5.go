@@ -0,0 +1,224 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/SchumacherFM/prometheus_shelly_exporter/collector"
+	"github.com/SchumacherFM/prometheus_shelly_exporter/config"
+	"github.com/eclipse/paho.golang/autopaho"
+	"github.com/eclipse/paho.golang/paho"
+	"github.com/eclipse/paho.mqtt.golang"
+	"github.com/gorilla/websocket"
+	"go.uber.org/zap"
+)
+
+// mqtt5Client is the paho.golang MQTT 5 client. With a share group all
+// subscriptions become $share/<group>/<topic>, so the exporters of the group
+// receive each message only once.
+type mqtt5Client struct {
+	cm         *autopaho.ConnectionManager
+	shareGroup string
+	userProps  paho.UserProperties
+
+	mu        sync.RWMutex
+	subs      map[string]subscription // topic filter without $share
+	connected bool
+}
+
+func newMQTT5Client(cfg config.MQTT, tr transport, stats *mqttStats, log *zap.Logger) (*mqtt5Client, error) {
+	c := &mqtt5Client{
+		shareGroup: cfg.ShareGroup,
+		subs:       make(map[string]subscription),
+	}
+	for k, v := range cfg.UserProperties {
+		c.userProps.Add(k, v)
+	}
+
+	ac := autopaho.ClientConfig{
+		ServerUrls: tr.servers,
+		TlsCfg:     tr.tls,
+		WebSocketCfg: &autopaho.WebSocketConfig{
+			Dialer: func(_ *url.URL, tlsCfg *tls.Config) *websocket.Dialer {
+				d := *websocket.DefaultDialer
+				d.Proxy = tr.proxy
+				d.TLSClientConfig = tlsCfg
+				d.Subprotocols = []string{"mqtt"}
+				return &d
+			},
+			Header: func(*url.URL, *tls.Config) http.Header {
+				return tr.header
+			},
+		},
+		KeepAlive:                     uint16(cfg.KeepAlive / time.Second),
+		CleanStartOnInitialConnection: !cfg.PersistentSession(),
+		SessionExpiryInterval:         uint32(cfg.SessionExpiry / time.Second),
+		ConnectTimeout:                cfg.ConnectTimeout,
+		ReconnectBackoff:              backoff,
+		ConnectUsername:               cfg.Username,
+		ConnectPassword:               []byte(cfg.Password),
+		ConnectPacketBuilder: func(cp *paho.Connect, _ *url.URL) (*paho.Connect, error) {
+			if len(c.userProps) > 0 {
+				if cp.Properties == nil {
+					cp.Properties = &paho.ConnectProperties{}
+				}
+				cp.Properties.User = c.userProps
+			}
+			return cp, nil
+		},
+		OnConnectionUp: func(cm *autopaho.ConnectionManager, _ *paho.Connack) {
+			c.mu.Lock()
+			c.connected = true
+			subs := maps.Clone(c.subs)
+			c.mu.Unlock()
+			stats.connected.Set(1)
+			log.Info("connected to the broker")
+			// OnConnectionUp must not block
+			go resubscribe(subs, func(topic string, sub subscription) error {
+				return c.subscribe(cm, topic, sub)
+			}, stats, log)
+		},
+		OnConnectionDown: func() bool {
+			c.mu.Lock()
+			c.connected = false
+			c.mu.Unlock()
+			stats.connected.Set(0)
+			stats.reconnects.Inc() // autopaho reconnects with backoff
+			log.Warn("connection to the broker lost")
+			return true
+		},
+		OnConnectError: func(err error) {
+			log.Warn("connecting to the broker failed", zap.Error(err))
+		},
+		ClientConfig: paho.ClientConfig{
+			ClientID:          cfg.ClientID,
+			OnPublishReceived: []func(paho.PublishReceived) (bool, error){c.route},
+		},
+	}
+
+	// connects in the background until Disconnect
+	cm, err := autopaho.NewConnection(context.Background(), ac)
+	if err != nil {
+		return nil, err
+	}
+	c.cm = cm
+	return c, nil
+}
+
+// route hands a message to the handler of the first matching subscription,
+// overlapping subscriptions would otherwise count it twice.
+func (c *mqtt5Client) route(pr paho.PublishReceived) (bool, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	for filter, sub := range c.subs {
+		if collector.TopicMatches(filter, pr.Packet.Topic) {
+			sub.handler(message5{pr.Packet})
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// shared returns the topic filter of the subscription.
+func (c *mqtt5Client) shared(topic string) string {
+	if c.shareGroup == "" {
+		return topic
+	}
+	return "$share/" + c.shareGroup + "/" + topic
+}
+
+func (c *mqtt5Client) Subscribe(topic string, qos byte, handler func(mqtt.Message)) error {
+	sub := subscription{qos: qos, handler: handler}
+	c.mu.Lock()
+	c.subs[topic] = sub
+	connected := c.connected
+	c.mu.Unlock()
+
+	if !connected {
+		return nil // OnConnectionUp subscribes
+	}
+	err := c.subscribe(c.cm, topic, sub)
+	if errors.Is(err, errRejected) {
+		c.mu.Lock()
+		delete(c.subs, topic)
+		c.mu.Unlock()
+	}
+	return err
+}
+
+// subscribe sends the SUBSCRIBE, cm is an argument as OnConnectionUp may run
+// before newMQTT5Client has set c.cm.
+func (c *mqtt5Client) subscribe(cm *autopaho.ConnectionManager, topic string, sub subscription) error {
+	ctx, cancel := context.WithTimeout(context.Background(), opTimeout)
+	defer cancel()
+	sa, err := cm.Subscribe(ctx, &paho.Subscribe{
+		Properties:    &paho.SubscribeProperties{User: c.userProps},
+		Subscriptions: []paho.SubscribeOptions{{Topic: c.shared(topic), QoS: sub.qos}},
+	})
+	if err == nil && len(sa.Reasons) > 0 && sa.Reasons[0] >= subackFailure {
+		err = fmt.Errorf("%w with reason 0x%02x", errRejected, sa.Reasons[0])
+	}
+	return err
+}
+
+func (c *mqtt5Client) Unsubscribe(topics ...string) error {
+	c.mu.Lock()
+	for _, topic := range topics {
+		delete(c.subs, topic)
+	}
+	connected := c.connected
+	c.mu.Unlock()
+
+	if !connected {
+		return nil
+	}
+	shared := make([]string, 0, len(topics))
+	for _, topic := range topics {
+		shared = append(shared, c.shared(topic))
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), opTimeout)
+	defer cancel()
+	_, err := c.cm.Unsubscribe(ctx, &paho.Unsubscribe{Topics: shared})
+	return err
+}
+
+func (c *mqtt5Client) Publish(topic string, qos byte, payload []byte) {
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), opTimeout)
+		defer cancel()
+		c.cm.Publish(ctx, &paho.Publish{
+			Topic:      topic,
+			QoS:        qos,
+			Payload:    payload,
+			Properties: &paho.PublishProperties{User: c.userProps},
+		})
+	}()
+}
+
+func (c *mqtt5Client) Disconnect() {
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	c.cm.Disconnect(ctx)
+}
+
+// message5 is an MQTT 5 PUBLISH as mqtt.Message, paho.golang acknowledges it
+// on its own.
+type message5 struct {
+	p *paho.Publish
+}
+
+func (m message5) Duplicate() bool   { return m.p.Duplicate() }
+func (m message5) Qos() byte         { return m.p.QoS }
+func (m message5) Retained() bool    { return m.p.Retain }
+func (m message5) Topic() string     { return m.p.Topic }
+func (m message5) MessageID() uint16 { return m.p.PacketID }
+func (m message5) Payload() []byte   { return m.p.Payload }
+func (m message5) Ack()              {}
@@ -0,0 +1,541 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/SchumacherFM/prometheus_shelly_exporter/config"
+	"github.com/eclipse/paho.mqtt.golang"
+	mochi "github.com/mochi-mqtt/server/v2"
+	"github.com/mochi-mqtt/server/v2/hooks/auth"
+	"github.com/mochi-mqtt/server/v2/listeners"
+	"github.com/mochi-mqtt/server/v2/packets"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+// testPKI is a CA with a broker certificate for broker.test and a client
+// certificate, written as PEM files to dir.
+type testPKI struct {
+	dir    string
+	pool   *x509.CertPool
+	server tls.Certificate
+}
+
+func newTestPKI(t *testing.T) *testPKI {
+	t.Helper()
+	p := &testPKI{dir: t.TempDir(), pool: x509.NewCertPool()}
+
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	caTmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTmpl, caTmpl, &caKey.PublicKey, caKey)
+	require.NoError(t, err)
+	ca, err := x509.ParseCertificate(caDER)
+	require.NoError(t, err)
+	p.pool.AddCert(ca)
+	p.writePEM(t, "ca.pem", "CERTIFICATE", caDER)
+
+	issue := func(serial int64, name string, usage x509.ExtKeyUsage) tls.Certificate {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		require.NoError(t, err)
+		tmpl := &x509.Certificate{
+			SerialNumber: big.NewInt(serial),
+			Subject:      pkix.Name{CommonName: name},
+			DNSNames:     []string{name},
+			NotBefore:    time.Now().Add(-time.Hour),
+			NotAfter:     time.Now().Add(time.Hour),
+			KeyUsage:     x509.KeyUsageDigitalSignature,
+			ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+		}
+		der, err := x509.CreateCertificate(rand.Reader, tmpl, ca, &key.PublicKey, caKey)
+		require.NoError(t, err)
+		keyDER, err := x509.MarshalECPrivateKey(key)
+		require.NoError(t, err)
+		p.writePEM(t, name+".pem", "CERTIFICATE", der)
+		p.writePEM(t, name+"-key.pem", "EC PRIVATE KEY", keyDER)
+		cert, err := tls.LoadX509KeyPair(p.path(name+".pem"), p.path(name+"-key.pem"))
+		require.NoError(t, err)
+		return cert
+	}
+	p.server = issue(2, "broker.test", x509.ExtKeyUsageServerAuth)
+	issue(3, "exporter", x509.ExtKeyUsageClientAuth)
+	return p
+}
+
+func (p *testPKI) path(name string) string {
+	return filepath.Join(p.dir, name)
+}
+
+func (p *testPKI) writePEM(t *testing.T, name, typ string, der []byte) {
+	t.Helper()
+	data := pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: der})
+	require.NoError(t, os.WriteFile(p.path(name), data, 0o600))
+}
+
+// newTestBroker starts an MQTT broker on a random local port and returns it
+// with its address. The broker publishes with s.Publish.
+func newTestBroker(t *testing.T, l func(addr string) listeners.Listener, hooks ...mochi.Hook) (*mochi.Server, string) {
+	t.Helper()
+	s, addr := startTestBroker(t, "127.0.0.1:0", l, hooks...)
+	t.Cleanup(func() { s.Close() })
+	return s, addr
+}
+
+// startTestBroker starts an MQTT broker on addr, the caller closes it.
+func startTestBroker(t *testing.T, addr string, l func(addr string) listeners.Listener, hooks ...mochi.Hook) (*mochi.Server, string) {
+	t.Helper()
+	s := mochi.New(&mochi.Options{
+		Logger:       slog.New(slog.NewTextHandler(io.Discard, nil)),
+		InlineClient: true,
+	})
+	require.NoError(t, s.AddHook(new(auth.AllowHook), nil))
+	for _, h := range hooks {
+		require.NoError(t, s.AddHook(h, nil))
+	}
+	ln := l(addr)
+	require.NoError(t, s.AddListener(ln))
+	require.NoError(t, s.Serve())
+	return s, ln.Address()
+}
+
+// freeAddr returns a local address nothing listens on.
+func freeAddr(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close()
+	return ln.Addr().String()
+}
+
+func tcpListener(addr string) listeners.Listener {
+	return listeners.NewTCP(listeners.Config{ID: "tcp", Address: addr})
+}
+
+func TestNewMQTTClient_TLS(t *testing.T) {
+	pki := newTestPKI(t)
+	_, addr := newTestBroker(t, func(addr string) listeners.Listener {
+		return listeners.NewTCP(listeners.Config{ID: "tls", Address: addr, TLSConfig: &tls.Config{
+			Certificates: []tls.Certificate{pki.server},
+			ClientCAs:    pki.pool,
+			ClientAuth:   tls.RequireAndVerifyClientCert,
+		}})
+	})
+	mTLS := config.TLS{
+		CAFile:     pki.path("ca.pem"),
+		CertFile:   pki.path("exporter.pem"),
+		KeyFile:    pki.path("exporter-key.pem"),
+		ServerName: "broker.test",
+	}
+
+	tests := map[string]struct {
+		tls     func(config.TLS) config.TLS
+		wantErr bool
+	}{
+		"mutual TLS": {
+			tls: func(c config.TLS) config.TLS { return c },
+		},
+		"without client certificate": {
+			tls: func(c config.TLS) config.TLS {
+				c.CertFile, c.KeyFile = "", ""
+				return c
+			},
+			wantErr: true,
+		},
+		"unknown CA": {
+			tls: func(c config.TLS) config.TLS {
+				c.CAFile = ""
+				return c
+			},
+			wantErr: true,
+		},
+		"server name of the URL": {
+			tls: func(c config.TLS) config.TLS {
+				c.ServerName = ""
+				return c
+			},
+			wantErr: true,
+		},
+		"insecure skip verify": {
+			tls: func(c config.TLS) config.TLS {
+				c.CAFile, c.ServerName, c.InsecureSkipVerify = "", "", true
+				return c
+			},
+		},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			cfg := &config.Config{MQTT: config.MQTT{
+				URLs: []string{"mqtts://" + addr},
+				TLS:  test.tls(mTLS),
+			}}
+			stats := newMQTTStats()
+			_, cancel, err := newMQTTClient(cfg, stats, zap.NewNop())
+			require.NoError(t, err)
+			defer cancel()
+			if test.wantErr {
+				assert.Never(t, func() bool {
+					return testutil.ToFloat64(stats.connected) == 1
+				}, 300*time.Millisecond, 10*time.Millisecond)
+				return
+			}
+			waitConnected(t, stats)
+		})
+	}
+}
+
+// waitConnected waits for the client of stats to connect, the clients connect
+// in the background.
+func waitConnected(t *testing.T, stats *mqttStats) {
+	t.Helper()
+	require.Eventually(t, func() bool {
+		return testutil.ToFloat64(stats.connected) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestNewTLSConfig(t *testing.T) {
+	tc, err := newTLSConfig(config.TLS{})
+	require.NoError(t, err)
+	assert.Nil(t, tc, "paho defaults")
+
+	_, err = newTLSConfig(config.TLS{CAFile: "testdata/missing.pem"})
+	assert.Error(t, err)
+
+	empty := filepath.Join(t.TempDir(), "empty.pem")
+	require.NoError(t, os.WriteFile(empty, nil, 0o600))
+	_, err = newTLSConfig(config.TLS{CAFile: empty})
+	assert.ErrorContains(t, err, "no certificates")
+}
+
+// connectHook records the CONNECT packets.
+type connectHook struct {
+	mochi.HookBase
+
+	mu       sync.Mutex
+	connects []packets.Packet
+}
+
+func (h *connectHook) ID() string { return "connect" }
+
+func (h *connectHook) Provides(b byte) bool { return b == mochi.OnConnect }
+
+func (h *connectHook) OnConnect(_ *mochi.Client, pk packets.Packet) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.connects = append(h.connects, pk)
+	return nil
+}
+
+func TestNewMQTTClient_MQTT5(t *testing.T) {
+	hook := &connectHook{}
+	broker, addr := newTestBroker(t, tcpListener, hook)
+	cfg := &config.Config{MQTT: config.MQTT{
+		URLs:           []string{"mqtt://" + addr},
+		Version:        5,
+		ShareGroup:     "exporters",
+		UserProperties: map[string]string{"instance": "a"},
+		SessionExpiry:  time.Hour,
+	}}
+
+	// two exporters of a share group get each message once
+	var received atomic.Int32
+	for range 2 {
+		stats := newMQTTStats()
+		mqc, cancel, err := newMQTTClient(cfg, stats, zap.NewNop())
+		require.NoError(t, err)
+		defer cancel()
+		waitConnected(t, stats)
+		require.NoError(t, mqc.Subscribe("shellies/+/info", 0, func(msg mqtt.Message) {
+			assert.Equal(t, "shellies/shellyht-6FDA5D/info", msg.Topic())
+			received.Add(1)
+		}))
+	}
+	for range 10 {
+		require.NoError(t, broker.Publish("shellies/shellyht-6FDA5D/info", []byte(`{}`), false, 0))
+	}
+	assert.Eventually(t, func() bool { return received.Load() == 10 }, time.Second, 10*time.Millisecond)
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, int32(10), received.Load(), "no duplicates")
+
+	hook.mu.Lock()
+	defer hook.mu.Unlock()
+	require.Len(t, hook.connects, 2)
+	pk := hook.connects[0]
+	assert.Equal(t, byte(5), pk.ProtocolVersion)
+	assert.Equal(t, uint32(3600), pk.Properties.SessionExpiryInterval)
+	assert.Equal(t, []packets.UserProperty{{Key: "instance", Val: "a"}}, pk.Properties.User)
+}
+
+func TestNewMQTTClient_resubscribe(t *testing.T) {
+	tests := map[string]config.MQTT{
+		"MQTT 3.1.1": {},
+		"MQTT 3.1.1 persistent session": {
+			ClientID:     "exporter",
+			CleanSession: new(bool),
+		},
+		"MQTT 5": {Version: 5},
+		"MQTT 5 persistent session": {
+			Version:       5,
+			ClientID:      "exporter",
+			CleanSession:  new(bool),
+			SessionExpiry: time.Hour,
+		},
+	}
+	for name, mc := range tests {
+		t.Run(name, func(t *testing.T) {
+			hook := &connectHook{}
+			broker, addr := startTestBroker(t, "127.0.0.1:0", tcpListener, hook)
+			mc.URLs = []string{"mqtt://" + addr}
+			mc.KeepAlive = 5 * time.Second
+			mc.ConnectTimeout = time.Second
+			stats := newMQTTStats()
+			mqc, cancel, err := newMQTTClient(&config.Config{MQTT: mc}, stats, zap.NewNop())
+			require.NoError(t, err)
+			defer cancel()
+			waitConnected(t, stats)
+
+			var received atomic.Int32
+			require.NoError(t, mqc.Subscribe("shellies/+/info", 0, func(mqtt.Message) {
+				received.Add(1)
+			}))
+			require.NoError(t, broker.Publish("shellies/shellyht-6FDA5D/info", []byte(`{}`), false, 0))
+			assert.Eventually(t, func() bool { return received.Load() == 1 }, time.Second, 10*time.Millisecond)
+
+			hook.mu.Lock()
+			pk := hook.connects[0]
+			hook.mu.Unlock()
+			if mc.ClientID != "" {
+				assert.Equal(t, mc.ClientID, string(pk.Connect.ClientIdentifier))
+			}
+			assert.Equal(t, uint16(5), pk.Connect.Keepalive)
+			assert.Equal(t, !mc.PersistentSession(), pk.Connect.Clean)
+
+			// the new broker knows nothing of the session
+			require.NoError(t, broker.Close())
+			assert.Eventually(t, func() bool {
+				return testutil.ToFloat64(stats.connected) == 0
+			}, time.Second, 10*time.Millisecond)
+			broker, _ = startTestBroker(t, addr, tcpListener)
+			defer broker.Close()
+			assert.Eventually(t, func() bool {
+				require.NoError(t, broker.Publish("shellies/shellyht-6FDA5D/info", []byte(`{}`), false, 0))
+				return received.Load() > 1
+			}, 10*time.Second, 100*time.Millisecond)
+			assert.Equal(t, 1.0, testutil.ToFloat64(stats.connected))
+			assert.GreaterOrEqual(t, testutil.ToFloat64(stats.reconnects), 1.0)
+		})
+	}
+}
+
+func TestNewMQTTClient_overlappingFilters(t *testing.T) {
+	for name, version := range map[string]int{"MQTT 3.1.1": 0, "MQTT 5": 5} {
+		t.Run(name, func(t *testing.T) {
+			broker, addr := newTestBroker(t, tcpListener)
+			stats := newMQTTStats()
+			mqc, cancel, err := newMQTTClient(&config.Config{MQTT: config.MQTT{
+				URLs:    []string{"mqtt://" + addr},
+				Version: version,
+			}}, stats, zap.NewNop())
+			require.NoError(t, err)
+			defer cancel()
+			waitConnected(t, stats)
+
+			// both filters match, each message gets handled once
+			var received atomic.Int32
+			for _, topic := range []string{"shellies/#", "shellies/+/info"} {
+				require.NoError(t, mqc.Subscribe(topic, 0, func(mqtt.Message) {
+					received.Add(1)
+				}))
+			}
+			for range 10 {
+				require.NoError(t, broker.Publish("shellies/shellyht-6FDA5D/info", []byte(`{}`), false, 0))
+			}
+			assert.Eventually(t, func() bool { return received.Load() == 10 }, time.Second, 10*time.Millisecond)
+			time.Sleep(50 * time.Millisecond)
+			assert.Equal(t, int32(10), received.Load(), "no duplicates")
+		})
+	}
+}
+
+func TestNewMQTTClient_brokerDown(t *testing.T) {
+	for _, version := range []int{3, 5} {
+		t.Run(fmt.Sprintf("MQTT %d", version), func(t *testing.T) {
+			addr := freeAddr(t) // without a broker
+
+			stats := newMQTTStats()
+			mqc, cancel, err := newMQTTClient(&config.Config{MQTT: config.MQTT{
+				URLs:    []string{"mqtt://" + addr},
+				Version: version,
+			}}, stats, zap.NewNop())
+			require.NoError(t, err, "no waiting for the broker")
+			defer cancel()
+
+			var received atomic.Int32
+			require.NoError(t, mqc.Subscribe("shellies/+/info", 0, func(mqtt.Message) {
+				received.Add(1)
+			}), "subscribes on connect")
+			time.Sleep(100 * time.Millisecond)
+			assert.Equal(t, 0.0, testutil.ToFloat64(stats.connected))
+
+			broker, _ := startTestBroker(t, addr, tcpListener)
+			defer broker.Close()
+			waitConnected(t, stats)
+			assert.Eventually(t, func() bool {
+				require.NoError(t, broker.Publish("shellies/shellyht-6FDA5D/info", []byte(`{}`), false, 0))
+				return received.Load() > 0
+			}, time.Second, 50*time.Millisecond)
+			assert.Equal(t, 0.0, testutil.ToFloat64(stats.reconnects), "not lost yet")
+		})
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	assert.Zero(t, backoff(0))
+	for attempt, want := range map[int]time.Duration{
+		1:   time.Second,
+		2:   2 * time.Second,
+		5:   16 * time.Second,
+		8:   maxBackoff,
+		100: maxBackoff,
+	} {
+		for range 100 {
+			d := backoff(attempt)
+			assert.GreaterOrEqual(t, d, want/2, "attempt %d", attempt)
+			assert.LessOrEqual(t, d, want, "attempt %d", attempt)
+		}
+	}
+}
+
+// connectProxy is an HTTP proxy which only tunnels CONNECT requests.
+func connectProxy(t *testing.T, tunnels *atomic.Int32) *httptest.Server {
+	t.Helper()
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodConnect {
+			http.Error(w, "CONNECT only", http.StatusMethodNotAllowed)
+			return
+		}
+		dst, err := net.Dial("tcp", r.Host)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		defer dst.Close()
+		src, _, err := http.NewResponseController(w).Hijack()
+		if err != nil {
+			return
+		}
+		defer src.Close()
+		tunnels.Add(1)
+		io.WriteString(src, "HTTP/1.1 200 Connection established\r\n\r\n")
+		go io.Copy(dst, src)
+		io.Copy(src, dst)
+	}))
+	t.Cleanup(proxy.Close)
+	return proxy
+}
+
+func TestNewMQTTClient_WebSocket(t *testing.T) {
+	broker, addr := startTestBroker(t, freeAddr(t), func(addr string) listeners.Listener {
+		return listeners.NewWebsocket(listeners.Config{ID: "ws", Address: addr})
+	})
+	defer broker.Close()
+
+	// the reverse proxy in front of the broker wants a token on /mqtt
+	backend, err := url.Parse("http://" + addr)
+	require.NoError(t, err)
+	front := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/mqtt" || r.Header.Get("Authorization") != "Bearer secret" {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		httputil.NewSingleHostReverseProxy(backend).ServeHTTP(w, r)
+	}))
+	defer front.Close()
+	var tunnels atomic.Int32
+	proxy := connectProxy(t, &tunnels)
+
+	tests := map[string]struct {
+		version   int
+		ws        config.WebSocket
+		connected bool
+	}{
+		"MQTT 3.1.1": {
+			version:   3,
+			ws:        config.WebSocket{Headers: map[string]string{"Authorization": "Bearer secret"}, Proxy: proxy.URL},
+			connected: true,
+		},
+		"MQTT 5": {
+			version:   5,
+			ws:        config.WebSocket{Headers: map[string]string{"Authorization": "Bearer secret"}, Proxy: proxy.URL},
+			connected: true,
+		},
+		"MQTT 3.1.1 without token": {
+			version: 3,
+			ws:      config.WebSocket{Proxy: proxy.URL},
+		},
+		"MQTT 5 without token": {
+			version: 5,
+			ws:      config.WebSocket{Proxy: proxy.URL},
+		},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			tunnels.Store(0)
+			stats := newMQTTStats()
+			mqc, cancel, err := newMQTTClient(&config.Config{MQTT: config.MQTT{
+				URLs:      []string{"ws://" + strings.TrimPrefix(front.URL, "http://") + "/mqtt"},
+				Version:   test.version,
+				WebSocket: test.ws,
+			}}, stats, zap.NewNop())
+			require.NoError(t, err)
+			defer cancel()
+			if !test.connected {
+				assert.Never(t, func() bool {
+					return testutil.ToFloat64(stats.connected) == 1
+				}, 300*time.Millisecond, 10*time.Millisecond)
+				assert.Positive(t, tunnels.Load(), "via the proxy")
+				return
+			}
+			waitConnected(t, stats)
+			assert.Positive(t, tunnels.Load(), "via the proxy")
+
+			var received atomic.Int32
+			require.NoError(t, mqc.Subscribe("shellies/+/info", 0, func(mqtt.Message) {
+				received.Add(1)
+			}))
+			require.NoError(t, broker.Publish("shellies/shellyht-6FDA5D/info", []byte(`{}`), false, 0))
+			assert.Eventually(t, func() bool { return received.Load() == 1 }, time.Second, 10*time.Millisecond)
+		})
+	}
+}
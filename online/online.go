@@ -0,0 +1,128 @@
+package online
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/SchumacherFM/prometheus_shelly_exporter/collector"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+// Devices set a retained last will of false on their online topic and publish
+// true after connecting:
+//
+//	shellies/<id>/online  Gen1
+//	<prefix>/online       Gen2, the prefix defaults to the device ID
+//
+// The state gets stored in the shared collector.Presence so that all
+// collectors can skip offline devices.
+
+type Collector struct {
+	opts            Options
+	onlineDesc      *prometheus.Desc
+	transitionsDesc *prometheus.Desc
+	health          *collector.Health
+}
+
+type Options struct {
+	collector.Options
+	TestCB func()
+}
+
+func init() {
+	collector.Register(collector.Definition{
+		Name:          "online",
+		Help:          "online state from the last will of Gen1 and Gen2 devices",
+		Default:       true,
+		Subscriptions: []string{"shellies/+/online", "+/online"},
+		New: func(ctx context.Context, messageChan <-chan mqtt.Message, opts collector.Options) prometheus.Collector {
+			return NewCollector(ctx, messageChan, Options{Options: opts})
+		},
+	})
+}
+
+func NewCollector(ctx context.Context, messageChan <-chan mqtt.Message, opts Options) *Collector {
+	if opts.Presence == nil {
+		opts.Presence = collector.NewPresence()
+	}
+	// the up of an offline device still tells whether its last will was fine
+	healthOpts := opts.Options
+	healthOpts.Presence = nil
+	c := &Collector{
+		opts:            opts,
+		onlineDesc:      prometheus.NewDesc("shelly_device_online", "1 if the device is connected to the broker", []string{"device"}, nil),
+		transitionsDesc: prometheus.NewDesc("shelly_device_online_transitions_total", "changes between online and offline since the exporter started", []string{"device"}, nil),
+		health:          collector.NewHealth("shelly_online", healthOpts),
+	}
+
+	go func() {
+		for {
+			select {
+			case msg, ok := <-messageChan:
+				if !ok {
+					if opts.TestCB != nil {
+						opts.TestCB()
+					}
+					return
+				}
+
+				c.handle(msg.Topic(), msg.Payload())
+
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return c
+}
+
+func (c *Collector) handle(topic string, payload []byte) {
+	var devID string
+	switch topicPaths := strings.Split(topic, "/"); {
+	case len(topicPaths) == 3 && topicPaths[0] == "shellies" && topicPaths[2] == "online":
+		devID = topicPaths[1]
+	case len(topicPaths) == 2 && topicPaths[1] == "online":
+		devID = topicPaths[0]
+	default:
+		return
+	}
+
+	var online bool
+	switch strings.TrimSpace(string(payload)) {
+	case "true":
+		online = true
+	case "false":
+	default:
+		c.health.ParseError(devID, topic, fmt.Errorf("online: unexpected payload %q", payload))
+		return
+	}
+
+	c.opts.Log.Debug("message from mqtt",
+		zap.String("topic", topic),
+		zap.Bool("online", online))
+
+	c.opts.Presence.Set(devID, online)
+	c.health.OK(devID)
+}
+
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.onlineDesc
+	ch <- c.transitionsDesc
+	c.health.Describe(ch)
+}
+
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	c.opts.Presence.Each(func(device string, online bool, transitions float64) {
+		var v float64
+		if online {
+			v = 1
+		}
+		ch <- prometheus.MustNewConstMetric(c.onlineDesc, prometheus.GaugeValue, v, device)
+		ch <- prometheus.MustNewConstMetric(c.transitionsDesc, prometheus.CounterValue, transitions, device)
+	})
+	c.health.Collect(ch)
+}
@@ -0,0 +1,70 @@
+package online
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/SchumacherFM/prometheus_shelly_exporter/collector"
+	"github.com/SchumacherFM/prometheus_shelly_exporter/collector/collectortest"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+var _ prometheus.Collector = (*Collector)(nil)
+
+func TestCollector_collect(t *testing.T) {
+	ctx := context.Background()
+	msgChan := make(chan mqtt.Message)
+
+	log, _ := zap.NewDevelopment(zap.Development())
+	msgGoRoutineDone := make(chan struct{})
+	c := NewCollector(ctx, msgChan, Options{
+		Options: collector.Options{Log: log},
+		TestCB: func() {
+			close(msgGoRoutineDone)
+		},
+	})
+
+	collectortest.SendFile(t, msgChan, "testdata/online.txt")
+	close(msgChan)
+	<-msgGoRoutineDone
+
+	err := testutil.CollectAndCompare(c, strings.NewReader(`
+# HELP shelly_device_online 1 if the device is connected to the broker
+# TYPE shelly_device_online gauge
+shelly_device_online{device="shellyht-6FDA5D"} 0
+shelly_device_online{device="shellyhtg3-e4b063d4a1b2"} 0
+shelly_device_online{device="shellyplus1pm-a8032ab12345"} 1
+# HELP shelly_device_online_transitions_total changes between online and offline since the exporter started
+# TYPE shelly_device_online_transitions_total counter
+shelly_device_online_transitions_total{device="shellyht-6FDA5D"} 3
+shelly_device_online_transitions_total{device="shellyhtg3-e4b063d4a1b2"} 0
+shelly_device_online_transitions_total{device="shellyplus1pm-a8032ab12345"} 0
+# HELP shelly_online_scrape_errors_total readings of the device which failed
+# TYPE shelly_online_scrape_errors_total counter
+shelly_online_scrape_errors_total{device="shellyht-6FDA5D",reason="invalid_reading"} 0
+shelly_online_scrape_errors_total{device="shellyht-6FDA5D",reason="parse_error"} 0
+shelly_online_scrape_errors_total{device="shellyht-6FDA5D",reason="stale"} 0
+shelly_online_scrape_errors_total{device="shellyhtg3-e4b063d4a1b2",reason="invalid_reading"} 0
+shelly_online_scrape_errors_total{device="shellyhtg3-e4b063d4a1b2",reason="parse_error"} 1
+shelly_online_scrape_errors_total{device="shellyhtg3-e4b063d4a1b2",reason="stale"} 0
+shelly_online_scrape_errors_total{device="shellyplus1pm-a8032ab12345",reason="invalid_reading"} 0
+shelly_online_scrape_errors_total{device="shellyplus1pm-a8032ab12345",reason="parse_error"} 0
+shelly_online_scrape_errors_total{device="shellyplus1pm-a8032ab12345",reason="stale"} 0
+# HELP shelly_online_up 1 if the last reading of the device was fine
+# TYPE shelly_online_up gauge
+shelly_online_up{device="shellyht-6FDA5D"} 1
+shelly_online_up{device="shellyhtg3-e4b063d4a1b2"} 0
+shelly_online_up{device="shellyplus1pm-a8032ab12345"} 1
+`),
+		"shelly_device_online",
+		"shelly_device_online_transitions_total",
+		"shelly_online_scrape_errors_total",
+		"shelly_online_up",
+	)
+	require.NoError(t, err)
+}
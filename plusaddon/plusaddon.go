@@ -0,0 +1,246 @@
+package plusaddon
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/SchumacherFM/prometheus_shelly_exporter/collector"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/tidwall/gjson"
+	"go.uber.org/zap"
+)
+
+// Peripherals of the Gen2 Plus Add-on show up as components with an ID
+// starting at 100 in the RPC status notifications:
+//
+//	"temperature:100": {"id":100,"tC":21.4,"tF":70.5}
+//	"humidity:100":    {"id":100,"rh":48.2}
+//	"voltmeter:100":   {"id":100,"voltage":4.12,"xvoltage":null}
+//	"input:100":       {"id":100,"state":false} or {"id":100,"percent":32.1} or {"id":100,"counts":{"total":12},"freq":0}
+//
+// NotifyStatus only carries the changed fields, so the values get merged per
+// component.
+const firstPeripheralID = 100
+
+// fields lists the exported values per component type, the key is the gjson
+// path below the component.
+var fields = map[string][]string{
+	"temperature": {"tC", "tF"},
+	"humidity":    {"rh"},
+	"voltmeter":   {"voltage"},
+	"input":       {"state", "percent", "counts.total", "freq"},
+}
+
+type component struct {
+	typ    string
+	id     string
+	values map[string]float64 // field => value
+}
+
+type Collector struct {
+	opts         Options
+	tmpDesc      *prometheus.Desc
+	humDesc      *prometheus.Desc
+	voltageDesc  *prometheus.Desc
+	inputDesc    *prometheus.Desc
+	percentDesc  *prometheus.Desc
+	countsDesc   *prometheus.Desc
+	freqDesc     *prometheus.Desc
+	health       *collector.Health
+	fieldToDescs map[string]map[string]*prometheus.Desc // type => field => desc
+
+	mu      sync.Mutex
+	devices map[string]map[string]*component // device ID => component key => state
+}
+
+type Options struct {
+	collector.Options
+	TestCB func()
+}
+
+func init() {
+	collector.Register(collector.Definition{
+		Name:          "plusaddon",
+		Help:          "Gen2 Plus Add-on peripherals",
+		Default:       true,
+		Subscriptions: []string{"+/events/rpc"},
+		New: func(ctx context.Context, messageChan <-chan mqtt.Message, opts collector.Options) prometheus.Collector {
+			return NewCollector(ctx, messageChan, Options{Options: opts})
+		},
+	})
+}
+
+func NewCollector(ctx context.Context, messageChan <-chan mqtt.Message, opts Options) *Collector {
+	c := &Collector{
+		opts:        opts,
+		tmpDesc:     prometheus.NewDesc("shellyplusaddon_temperature", "temperature of an add-on sensor", []string{"device", "component_id", "unit"}, nil),
+		humDesc:     prometheus.NewDesc("shellyplusaddon_humidity", "humidity of an add-on sensor in percent", []string{"device", "component_id"}, nil),
+		voltageDesc: prometheus.NewDesc("shellyplusaddon_voltage", "voltage of the add-on voltmeter in Volts", []string{"device", "component_id"}, nil),
+		inputDesc:   prometheus.NewDesc("shellyplusaddon_input_state", "state of an add-on digital input", []string{"device", "component_id"}, nil),
+		percentDesc: prometheus.NewDesc("shellyplusaddon_input_percent", "value of an add-on analog input in percent", []string{"device", "component_id"}, nil),
+		countsDesc:  prometheus.NewDesc("shellyplusaddon_input_counts_total", "pulses counted by an add-on input in count mode", []string{"device", "component_id"}, nil),
+		freqDesc:    prometheus.NewDesc("shellyplusaddon_input_frequency", "pulse frequency of an add-on input in count mode in Hz", []string{"device", "component_id"}, nil),
+		health:      collector.NewHealth("shellyplusaddon", opts.Options),
+		devices:     make(map[string]map[string]*component),
+	}
+	c.fieldToDescs = map[string]map[string]*prometheus.Desc{
+		"humidity":  {"rh": c.humDesc},
+		"voltmeter": {"voltage": c.voltageDesc},
+		"input": {
+			"state":        c.inputDesc,
+			"percent":      c.percentDesc,
+			"counts.total": c.countsDesc,
+			"freq":         c.freqDesc,
+		},
+	}
+
+	go func() {
+		for {
+			select {
+			case msg, ok := <-messageChan:
+				if !ok {
+					if opts.TestCB != nil {
+						opts.TestCB()
+					}
+					return
+				}
+				if false == strings.HasSuffix(msg.Topic(), "/rpc") {
+					continue
+				}
+
+				c.handle(msg.Topic(), msg.Payload())
+
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return c
+}
+
+// peripheral splits a component key like temperature:100 and reports whether
+// it belongs to the add-on.
+func peripheral(key string) (typ, id string, ok bool) {
+	typ, id, ok = strings.Cut(key, ":")
+	if !ok {
+		return "", "", false
+	}
+	if _, known := fields[typ]; !known {
+		return "", "", false
+	}
+	n, err := strconv.Atoi(id)
+	if err != nil || n < firstPeripheralID {
+		return "", "", false
+	}
+	return typ, id, true
+}
+
+func (c *Collector) handle(topic string, payload []byte) {
+	r := gjson.ParseBytes(payload)
+	if m := r.Get("method").String(); m != "NotifyStatus" && m != "NotifyFullStatus" {
+		return
+	}
+	devID := r.Get("src").String()
+	if devID == "" {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var found, invalid bool
+	r.Get("params").ForEach(func(key, value gjson.Result) bool {
+		typ, id, ok := peripheral(key.String())
+		if !ok {
+			return true
+		}
+		found = true
+
+		comps, ok := c.devices[devID]
+		if !ok {
+			comps = make(map[string]*component)
+			c.devices[devID] = comps
+		}
+		comp, ok := comps[key.String()]
+		if !ok {
+			comp = &component{typ: typ, id: id, values: make(map[string]float64)}
+			comps[key.String()] = comp
+		}
+		for _, field := range fields[typ] {
+			v := value.Get(field)
+			switch {
+			case !v.Exists():
+				// not part of this NotifyStatus
+			case v.Type == gjson.Null:
+				// sensor disconnected or input mode changed
+				delete(comp.values, field)
+				invalid = invalid || typ != "input"
+			case v.IsBool():
+				comp.values[field] = 0
+				if v.Bool() {
+					comp.values[field] = 1
+				}
+			default:
+				comp.values[field] = v.Float()
+			}
+		}
+		return true
+	})
+
+	if found {
+		c.opts.Timestamps.Observe(devID, r.Get("params.ts").Float())
+		if invalid {
+			c.health.Error(devID, collector.ReasonInvalidReading)
+		} else {
+			c.health.OK(devID)
+		}
+		c.opts.Log.Debug("message from mqtt",
+			zap.String("topic", topic),
+			zap.Int("length", len(payload)))
+	}
+}
+
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.tmpDesc
+	ch <- c.humDesc
+	ch <- c.voltageDesc
+	ch <- c.inputDesc
+	ch <- c.percentDesc
+	ch <- c.countsDesc
+	ch <- c.freqDesc
+	c.health.Describe(ch)
+}
+
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	c.collect(ch)
+	c.health.Collect(ch)
+}
+
+func (c *Collector) collect(ch chan<- prometheus.Metric) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for devID, comps := range c.devices {
+		if c.opts.Presence.Offline(devID) {
+			continue
+		}
+		for _, comp := range comps {
+			for field, v := range comp.values {
+				switch {
+				case comp.typ == "temperature" && field == "tC":
+					ch <- prometheus.MustNewConstMetric(c.tmpDesc, prometheus.GaugeValue, v, devID, comp.id, "c")
+				case comp.typ == "temperature" && field == "tF":
+					ch <- prometheus.MustNewConstMetric(c.tmpDesc, prometheus.GaugeValue, v, devID, comp.id, "f")
+				case field == "counts.total":
+					ch <- prometheus.MustNewConstMetric(c.countsDesc, prometheus.CounterValue, v, devID, comp.id)
+				default:
+					ch <- prometheus.MustNewConstMetric(c.fieldToDescs[comp.typ][field], prometheus.GaugeValue, v, devID, comp.id)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package plusaddon
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/SchumacherFM/prometheus_shelly_exporter/collector"
+	"github.com/SchumacherFM/prometheus_shelly_exporter/collector/collectortest"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+var _ prometheus.Collector = (*Collector)(nil)
+
+func TestCollector_collect(t *testing.T) {
+	ctx := context.Background()
+	msgChan := make(chan mqtt.Message)
+
+	log, _ := zap.NewDevelopment(zap.Development())
+	msgGoRoutineDone := make(chan struct{})
+	c := NewCollector(ctx, msgChan, Options{
+		Options: collector.Options{Log: log},
+		TestCB: func() {
+			close(msgGoRoutineDone)
+		},
+	})
+
+	collectortest.SendFile(t, msgChan, "testdata/plusaddon.txt")
+	close(msgChan)
+	<-msgGoRoutineDone
+
+	err := testutil.CollectAndCompare(c, strings.NewReader(`
+# HELP shellyplusaddon_humidity humidity of an add-on sensor in percent
+# TYPE shellyplusaddon_humidity gauge
+shellyplusaddon_humidity{component_id="100",device="shellyplus1-a8032ab12345"} 48.2
+# HELP shellyplusaddon_input_percent value of an add-on analog input in percent
+# TYPE shellyplusaddon_input_percent gauge
+shellyplusaddon_input_percent{component_id="101",device="shellyplus1-a8032ab12345"} 32.1
+# HELP shellyplusaddon_input_state state of an add-on digital input
+# TYPE shellyplusaddon_input_state gauge
+shellyplusaddon_input_state{component_id="100",device="shellyplus1-a8032ab12345"} 1
+# HELP shellyplusaddon_scrape_errors_total readings of the device which failed
+# TYPE shellyplusaddon_scrape_errors_total counter
+shellyplusaddon_scrape_errors_total{device="shellyplus1-a8032ab12345",reason="invalid_reading"} 1
+shellyplusaddon_scrape_errors_total{device="shellyplus1-a8032ab12345",reason="parse_error"} 0
+shellyplusaddon_scrape_errors_total{device="shellyplus1-a8032ab12345",reason="stale"} 0
+# HELP shellyplusaddon_temperature temperature of an add-on sensor
+# TYPE shellyplusaddon_temperature gauge
+shellyplusaddon_temperature{component_id="100",device="shellyplus1-a8032ab12345",unit="c"} 56
+shellyplusaddon_temperature{component_id="100",device="shellyplus1-a8032ab12345",unit="f"} 132.8
+# HELP shellyplusaddon_up 1 if the last reading of the device was fine
+# TYPE shellyplusaddon_up gauge
+shellyplusaddon_up{device="shellyplus1-a8032ab12345"} 0
+# HELP shellyplusaddon_voltage voltage of the add-on voltmeter in Volts
+# TYPE shellyplusaddon_voltage gauge
+shellyplusaddon_voltage{component_id="100",device="shellyplus1-a8032ab12345"} 4.12
+`),
+		"shellyplusaddon_humidity",
+		"shellyplusaddon_input_counts_total",
+		"shellyplusaddon_input_frequency",
+		"shellyplusaddon_input_percent",
+		"shellyplusaddon_input_state",
+		"shellyplusaddon_scrape_errors_total",
+		"shellyplusaddon_temperature",
+		"shellyplusaddon_up",
+		"shellyplusaddon_voltage",
+	)
+	require.NoError(t, err)
+}
@@ -0,0 +1,309 @@
+package safety
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/SchumacherFM/prometheus_shelly_exporter/collector"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/tidwall/gjson"
+	"go.uber.org/zap"
+)
+
+// Safety sensors report their alarm state. Gen1 Flood and Smoke publish each
+// value on its own topic:
+//
+//	shellies/shellyflood-<id>/sensor/flood        true|false
+//	shellies/shellyflood-<id>/sensor/temperature  21.50
+//	shellies/shellyflood-<id>/sensor/battery      97
+//	shellies/shellysmoke-<id>/sensor/smoke        true|false
+//
+// The Plus Smoke sends the component smoke:0 with alarm and mute via RPC
+// notifications on <prefix>/events/rpc.
+
+type alarm struct {
+	active    bool
+	muted     bool
+	hasMute   bool
+	lastAlarm time.Time
+}
+
+type device struct {
+	alarms         map[string]*alarm // sensor => state
+	temperature    float64
+	hasTemperature bool
+	batPercent     float64
+	hasBatPercent  bool
+	batVoltage     float64
+	hasBatVoltage  bool
+}
+
+type Collector struct {
+	opts          Options
+	alarmDesc     *prometheus.Desc
+	muteDesc      *prometheus.Desc
+	lastAlarmDesc *prometheus.Desc
+	tmpDesc       *prometheus.Desc
+	batDesc       *prometheus.Desc
+	health        *collector.Health
+
+	mu      sync.Mutex
+	devices map[string]*device // device ID => state
+}
+
+type Options struct {
+	collector.Options
+	TestCB func()
+}
+
+func init() {
+	collector.Register(collector.Definition{
+		Name:          "safety",
+		Help:          "Shelly Flood, Smoke and Plus Smoke",
+		Default:       true,
+		Subscriptions: []string{"shellies/+/sensor/#", "+/events/rpc"},
+		New: func(ctx context.Context, messageChan <-chan mqtt.Message, opts collector.Options) prometheus.Collector {
+			return NewCollector(ctx, messageChan, Options{Options: opts})
+		},
+	})
+}
+
+func NewCollector(ctx context.Context, messageChan <-chan mqtt.Message, opts Options) *Collector {
+	c := &Collector{
+		opts:          opts,
+		alarmDesc:     prometheus.NewDesc("shellysafety_alarm", "1 if the sensor currently reports an alarm (flood or smoke)", []string{"device", "sensor"}, nil),
+		muteDesc:      prometheus.NewDesc("shellysafety_muted", "1 if the alarm has been muted on the device", []string{"device", "sensor"}, nil),
+		lastAlarmDesc: prometheus.NewDesc("shellysafety_last_alarm_timestamp_seconds", "unix time when the sensor last raised an alarm", []string{"device", "sensor"}, nil),
+		tmpDesc:       prometheus.NewDesc("shellysafety_temperature", "Sensor temperature in the unit configured on the device", []string{"device"}, nil),
+		batDesc:       prometheus.NewDesc("shellysafety_battery", "Sensor battery", []string{"device", "unit"}, nil),
+		health:        collector.NewHealth("shellysafety", opts.Options),
+		devices:       make(map[string]*device),
+	}
+
+	go func() {
+		for {
+			select {
+			case msg, ok := <-messageChan:
+				if !ok {
+					if opts.TestCB != nil {
+						opts.TestCB()
+					}
+					return
+				}
+
+				switch {
+				case strings.Contains(msg.Topic(), "/sensor/"):
+					c.handleGen1(msg.Topic(), msg.Payload())
+				case strings.HasSuffix(msg.Topic(), "/rpc"):
+					c.handleGen2(msg.Payload())
+				default:
+					continue
+				}
+				opts.Log.Debug("message from mqtt",
+					zap.String("topic", msg.Topic()),
+					zap.Int("length", len(msg.Payload())))
+
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return c
+}
+
+// device returns the state for the device ID and creates it if needed. The
+// caller must hold c.mu.
+func (c *Collector) device(devID string) *device {
+	d, ok := c.devices[devID]
+	if !ok {
+		d = &device{alarms: make(map[string]*alarm, 1)}
+		c.devices[devID] = d
+	}
+	return d
+}
+
+func (d *device) setAlarm(sensor string, active bool, at time.Time) *alarm {
+	a, ok := d.alarms[sensor]
+	if !ok {
+		a = &alarm{}
+		d.alarms[sensor] = a
+	}
+	if active && !a.active {
+		a.lastAlarm = at
+	}
+	a.active = active
+	return a
+}
+
+func (c *Collector) handleGen1(topic string, payload []byte) {
+	// shellies/shellyflood-<id>/sensor/flood
+	topicPaths := strings.Split(topic, "/")
+	if len(topicPaths) != 4 {
+		return
+	}
+	devID, value := topicPaths[1], strings.TrimSpace(string(payload))
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	switch sensor := topicPaths[3]; sensor {
+	case "flood", "smoke":
+		active, err := strconv.ParseBool(value)
+		if err != nil {
+			c.health.ParseError(devID, topic, err)
+			return
+		}
+		c.device(devID).setAlarm(sensor, active, time.Now())
+	case "temperature", "battery":
+		if !c.isSafety(devID) {
+			return
+		}
+		f64, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			c.health.ParseError(devID, topic, err)
+			return
+		}
+		d := c.device(devID)
+		if sensor == "temperature" {
+			d.temperature, d.hasTemperature = f64, true
+		} else {
+			d.batPercent, d.hasBatPercent = f64, true
+		}
+	default:
+		return
+	}
+	c.ok(devID, 0)
+}
+
+// isSafety reports whether the device has reported a flood or smoke sensor or
+// has the default ID of a Flood or Smoke, which may report its temperature
+// first. Others like the H&T share the sensor topics but are not our
+// business. The caller must hold c.mu.
+func (c *Collector) isSafety(devID string) bool {
+	if d, ok := c.devices[devID]; ok && len(d.alarms) > 0 {
+		return true
+	}
+	return strings.HasPrefix(devID, "shellyflood-") || strings.HasPrefix(devID, "shellysmoke-")
+}
+
+// ok records a valid reading of a safety device. The caller must hold c.mu.
+func (c *Collector) ok(devID string, ts float64) {
+	c.opts.Timestamps.Observe(devID, ts)
+	c.health.OK(devID)
+}
+
+func (c *Collector) handleGen2(payload []byte) {
+	r := gjson.ParseBytes(payload)
+	if m := r.Get("method").String(); m != "NotifyStatus" && m != "NotifyFullStatus" {
+		return
+	}
+	devID := r.Get("src").String()
+	if devID == "" {
+		return
+	}
+	at := time.Now()
+	ts := r.Get("params.ts").Float()
+	if ts > 0 {
+		at = time.Unix(0, int64(ts*float64(time.Second)))
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// the smoke component may follow devicepower:0
+	params := r.Get("params")
+	safety := c.isSafety(devID)
+	params.ForEach(func(key, _ gjson.Result) bool {
+		safety = safety || strings.HasPrefix(key.String(), "smoke:")
+		return !safety
+	})
+	if !safety {
+		return
+	}
+
+	params.ForEach(func(key, value gjson.Result) bool {
+		switch {
+		case strings.HasPrefix(key.String(), "smoke:"):
+			d := c.device(devID)
+			a, ok := d.alarms["smoke"]
+			if alarmRes := value.Get("alarm"); alarmRes.Exists() {
+				a = d.setAlarm("smoke", alarmRes.Bool(), at)
+				ok = true
+			}
+			if muteRes := value.Get("mute"); ok && muteRes.Exists() {
+				a.muted, a.hasMute = muteRes.Bool(), true
+			}
+		case key.String() == "devicepower:0":
+			d := c.device(devID)
+			if v := value.Get("battery.percent"); v.Exists() {
+				d.batPercent, d.hasBatPercent = v.Float(), true
+			}
+			if v := value.Get("battery.V"); v.Exists() {
+				d.batVoltage, d.hasBatVoltage = v.Float(), true
+			}
+		default:
+			return true
+		}
+		c.ok(devID, ts)
+		return true
+	})
+}
+
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.alarmDesc
+	ch <- c.muteDesc
+	ch <- c.lastAlarmDesc
+	ch <- c.tmpDesc
+	ch <- c.batDesc
+	c.health.Describe(ch)
+}
+
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	c.collect(ch)
+	c.health.Collect(ch)
+}
+
+func boolToFloat(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func (c *Collector) collect(ch chan<- prometheus.Metric) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for devID, d := range c.devices {
+		if c.opts.Presence.Offline(devID) {
+			continue
+		}
+		if len(d.alarms) == 0 {
+			// temperature and battery of an H&T or similar, not our business
+			continue
+		}
+		for sensor, a := range d.alarms {
+			ch <- prometheus.MustNewConstMetric(c.alarmDesc, prometheus.GaugeValue, boolToFloat(a.active), devID, sensor)
+			if a.hasMute {
+				ch <- prometheus.MustNewConstMetric(c.muteDesc, prometheus.GaugeValue, boolToFloat(a.muted), devID, sensor)
+			}
+			if !a.lastAlarm.IsZero() {
+				ch <- prometheus.MustNewConstMetric(c.lastAlarmDesc, prometheus.GaugeValue, float64(a.lastAlarm.UnixNano())/1e9, devID, sensor)
+			}
+		}
+		if d.hasTemperature {
+			ch <- prometheus.MustNewConstMetric(c.tmpDesc, prometheus.GaugeValue, d.temperature, devID)
+		}
+		if d.hasBatPercent {
+			ch <- prometheus.MustNewConstMetric(c.batDesc, prometheus.GaugeValue, d.batPercent, devID, "%")
+		}
+		if d.hasBatVoltage {
+			ch <- prometheus.MustNewConstMetric(c.batDesc, prometheus.GaugeValue, d.batVoltage, devID, "V")
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package safety
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/SchumacherFM/prometheus_shelly_exporter/collector"
+	"github.com/SchumacherFM/prometheus_shelly_exporter/collector/collectortest"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+var _ prometheus.Collector = (*Collector)(nil)
+
+func TestCollector_collect(t *testing.T) {
+	ctx := context.Background()
+	msgChan := make(chan mqtt.Message)
+
+	log, _ := zap.NewDevelopment(zap.Development())
+	msgGoRoutineDone := make(chan struct{})
+	stats := collector.NewStats()
+	c := NewCollector(ctx, msgChan, Options{
+		Options: collector.Options{Log: log, Stats: stats},
+		TestCB: func() {
+			close(msgGoRoutineDone)
+		},
+	})
+
+	collectortest.SendFile(t, msgChan, "testdata/safety.txt")
+	close(msgChan)
+	<-msgGoRoutineDone
+
+	err := testutil.CollectAndCompare(c, strings.NewReader(`
+# HELP shellysafety_alarm 1 if the sensor currently reports an alarm (flood or smoke)
+# TYPE shellysafety_alarm gauge
+shellysafety_alarm{device="shellyflood-A4CF12F45A01",sensor="flood"} 0
+shellysafety_alarm{device="shellyplussmoke-a8032ab12345",sensor="smoke"} 1
+shellysafety_alarm{device="shellysmoke-5C8B21",sensor="smoke"} 0
+# HELP shellysafety_battery Sensor battery
+# TYPE shellysafety_battery gauge
+shellysafety_battery{device="shellyflood-A4CF12F45A01",unit="%"} 97
+shellysafety_battery{device="shellyplussmoke-a8032ab12345",unit="%"} 91
+shellysafety_battery{device="shellyplussmoke-a8032ab12345",unit="V"} 2.98
+shellysafety_battery{device="shellysmoke-5C8B21",unit="%"} 88
+# HELP shellysafety_last_alarm_timestamp_seconds unix time when the sensor last raised an alarm
+# TYPE shellysafety_last_alarm_timestamp_seconds gauge
+shellysafety_last_alarm_timestamp_seconds{device="shellyplussmoke-a8032ab12345",sensor="smoke"} 1.7076410005e+09
+# HELP shellysafety_muted 1 if the alarm has been muted on the device
+# TYPE shellysafety_muted gauge
+shellysafety_muted{device="shellyplussmoke-a8032ab12345",sensor="smoke"} 1
+# HELP shellysafety_temperature Sensor temperature in the unit configured on the device
+# TYPE shellysafety_temperature gauge
+shellysafety_temperature{device="shellyflood-A4CF12F45A01"} 21.5
+# HELP shellysafety_up 1 if the last reading of the device was fine
+# TYPE shellysafety_up gauge
+shellysafety_up{device="shellyflood-A4CF12F45A01"} 1
+shellysafety_up{device="shellyplussmoke-a8032ab12345"} 1
+shellysafety_up{device="shellysmoke-5C8B21"} 1
+`),
+		"shellysafety_alarm",
+		"shellysafety_battery",
+		"shellysafety_last_alarm_timestamp_seconds",
+		"shellysafety_muted",
+		"shellysafety_temperature",
+		"shellysafety_up",
+	)
+	require.NoError(t, err)
+
+	// the H&Ts share the sensor topics and devicepower:0 but are no safety
+	// devices, not even their parse errors count
+	assert.Equal(t, 0, testutil.CollectAndCount(stats))
+	reg := prometheus.NewPedanticRegistry()
+	reg.MustRegister(c)
+	mfs, err := reg.Gather()
+	require.NoError(t, err)
+	for _, mf := range mfs {
+		for _, m := range mf.GetMetric() {
+			for _, l := range m.GetLabel() {
+				assert.NotContains(t, []string{"shellyht-IDxyz", "shellyhtg3-e4b063d4a1b2"}, l.GetValue(), mf.GetName())
+			}
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"slices"
+	"sync"
+
+	"github.com/SchumacherFM/prometheus_shelly_exporter/collector"
+	"github.com/SchumacherFM/prometheus_shelly_exporter/config"
+	"go.uber.org/zap"
+)
+
+// subscriptions keeps the MQTT subscriptions in sync with the configured
+// topics and hands all messages to the router.
+type subscriptions struct {
+	mqc    mqttClient
+	router *collector.Router
+	log    *zap.Logger
+	stats  *mqttStats
+
+	mu     sync.Mutex
+	subs   []config.Subscription
+	closed bool
+}
+
+// Set subscribes to new topics and unsubscribes from the ones no longer
+// configured. The topics are in the format of config.ParseSubscription, a
+// topic with a new QoS gets subscribed again.
+func (s *subscriptions) Set(topics []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return
+	}
+
+	var subs []config.Subscription
+	for _, topic := range topics {
+		sub, err := config.ParseSubscription(topic)
+		if err != nil {
+			s.log.Error("invalid subscription", zap.Error(err))
+			continue
+		}
+		subs = append(subs, sub)
+	}
+
+	var removed []string
+	for _, sub := range s.subs {
+		if !slices.ContainsFunc(subs, func(n config.Subscription) bool { return n.Topic == sub.Topic }) {
+			removed = append(removed, sub.Topic)
+		}
+	}
+	if len(removed) > 0 {
+		if err := s.mqc.Unsubscribe(removed...); err != nil {
+			s.log.Error("unsubscribe failed", zap.Error(err), zap.Strings("topics", removed))
+		} else {
+			s.log.Info("unsubscribed from", zap.Strings("topics", removed))
+		}
+	}
+
+	var current []config.Subscription
+	for _, sub := range subs {
+		if slices.Contains(s.subs, sub) {
+			current = append(current, sub)
+			continue
+		}
+		err := s.mqc.Subscribe(sub.Topic, sub.QoS, s.router.Route)
+		if err != nil {
+			s.log.Error("subscribe failed", zap.Error(err), zap.Stringer("topic", sub))
+			s.stats.subscribeFailures.WithLabelValues(sub.Topic).Inc()
+			continue
+		}
+		s.log.Info("subscribed to", zap.Stringer("topic", sub))
+		current = append(current, sub)
+	}
+	s.subs = current
+}
+
+// Close unsubscribes from all topics, later calls of Set do nothing.
+func (s *subscriptions) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.closed = true
+	if len(s.subs) > 0 {
+		topics := make([]string, 0, len(s.subs))
+		for _, sub := range s.subs {
+			topics = append(topics, sub.Topic)
+		}
+		s.mqc.Unsubscribe(topics...)
+	}
+	s.subs = nil
+}
+
+// collectorSubscriptions returns the minimal set of topic filters the enabled
+// collectors need, the default without --topic.
+func collectorSubscriptions(cfg *config.Config) []string {
+	var filters []string
+	for _, def := range collector.Definitions() {
+		if cc, ok := cfg.Collectors[def.Name]; ok && cc.Enabled != nil && *cc.Enabled {
+			filters = append(filters, def.Subscriptions...)
+		}
+	}
+	return collector.MinimalFilters(filters)
+}
+
+// checkSubscriptions warns about topic filters of enabled collectors which
+// the configured subscriptions don't cover.
+func checkSubscriptions(cfg *config.Config, log *zap.Logger) {
+	var subscribed []string
+	for _, topic := range cfg.Subscriptions {
+		if sub, err := config.ParseSubscription(topic); err == nil {
+			subscribed = append(subscribed, sub.Topic)
+		}
+	}
+	for _, def := range collector.Definitions() {
+		if cc, ok := cfg.Collectors[def.Name]; !ok || cc.Enabled == nil || !*cc.Enabled {
+			continue
+		}
+		for _, filter := range def.Subscriptions {
+			if !slices.ContainsFunc(subscribed, func(s string) bool { return collector.FilterCovers(s, filter) }) {
+				log.Warn("collector misses messages, the subscriptions don't cover its topic",
+					zap.String("collector", def.Name), zap.String("topic", filter))
+			}
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/SchumacherFM/prometheus_shelly_exporter/collector"
+	"github.com/SchumacherFM/prometheus_shelly_exporter/config"
+	"github.com/eclipse/paho.mqtt.golang"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+// fakeMQTTClient records the calls of subscriptions.
+type fakeMQTTClient struct {
+	calls []string
+}
+
+func (c *fakeMQTTClient) Subscribe(topic string, qos byte, _ func(mqtt.Message)) error {
+	c.calls = append(c.calls, fmt.Sprintf("subscribe %s %d", topic, qos))
+	return nil
+}
+
+func (c *fakeMQTTClient) Unsubscribe(topics ...string) error {
+	c.calls = append(c.calls, fmt.Sprintf("unsubscribe %v", topics))
+	return nil
+}
+
+func (c *fakeMQTTClient) Publish(string, byte, []byte) {}
+
+func (c *fakeMQTTClient) Disconnect() {}
+
+func TestSubscriptions_Set(t *testing.T) {
+	mqc := &fakeMQTTClient{}
+	s := &subscriptions{
+		mqc:    mqc,
+		router: collector.NewRouter(zap.NewNop(), 1),
+		log:    zap.NewNop(),
+		stats:  newMQTTStats(),
+	}
+
+	s.Set([]string{"shellies/+/info", "+/events/rpc@1"})
+	s.Set([]string{"shellies/+/info@2", "+/events/rpc@1", "shellies/+/emeter/#"})
+	s.Set([]string{"shellies/+/emeter/#"})
+	s.Close()
+	s.Set([]string{"shellies/#"})
+
+	assert.Equal(t, []string{
+		"subscribe shellies/+/info 0",
+		"subscribe +/events/rpc 1",
+		"subscribe shellies/+/info 2", // new QoS
+		"subscribe shellies/+/emeter/# 0",
+		"unsubscribe [shellies/+/info +/events/rpc]",
+		"unsubscribe [shellies/+/emeter/#]",
+	}, mqc.calls)
+}
+
+func TestCollectorSubscriptions(t *testing.T) {
+	enabled, disabled := true, false
+	cfg := &config.Config{Collectors: make(map[string]config.Collector)}
+	for _, def := range collector.Definitions() {
+		cfg.Collectors[def.Name] = config.Collector{Enabled: &enabled}
+	}
+	assert.Equal(t, []string{
+		"+/events/rpc",
+		"+/online",
+		"shellies/+/adc/+",
+		"shellies/+/emeter/#",
+		"shellies/+/ext_humidity/+",
+		"shellies/+/ext_switch/+",
+		"shellies/+/ext_temperature/+",
+		"shellies/+/ext_temperature_f/+",
+		"shellies/+/info",
+		"shellies/+/input/+",
+		"shellies/+/input_event/+",
+		"shellies/+/online",
+		"shellies/+/roller/#",
+		"shellies/+/sensor/#",
+		"shellies/+/status",
+		"shellies/announce",
+		"shelly_exporter/rpc",
+	}, collectorSubscriptions(cfg))
+
+	for name := range cfg.Collectors {
+		cfg.Collectors[name] = config.Collector{Enabled: &disabled}
+	}
+	cfg.Collectors["threeem"] = config.Collector{Enabled: &enabled}
+	cfg.Collectors["htgen3"] = config.Collector{Enabled: &enabled}
+	assert.Equal(t, []string{"+/events/rpc", "shellies/+/emeter/#"}, collectorSubscriptions(cfg))
+}
@@ -4,10 +4,10 @@ import (
 	"context"
 	"sort"
 	"strings"
-	"time"
 
 	"github.com/samber/lo"
 
+	"github.com/SchumacherFM/prometheus_shelly_exporter/collector"
 	"github.com/corestoreio/pkg/util/byteconv"
 	mqtt "github.com/eclipse/paho.mqtt.golang"
 	"github.com/prometheus/client_golang/prometheus"
@@ -24,14 +24,25 @@ type Collector struct {
 	totalReturnedDesc   *prometheus.Desc
 	energyDesc          *prometheus.Desc
 	energyReturnedDesc  *prometheus.Desc
-	upDesc              *prometheus.Desc
+	health              *collector.Health
 	topicValueCollector map[string]float64 // topic => value
 }
 
 type Options struct {
-	Timeout time.Duration
-	Log     *zap.Logger
-	TestCB  func()
+	collector.Options
+	TestCB func()
+}
+
+func init() {
+	collector.Register(collector.Definition{
+		Name:          "threeem",
+		Help:          "Shelly 3EM",
+		Default:       true,
+		Subscriptions: []string{"shellies/+/emeter/#"},
+		New: func(ctx context.Context, messageChan <-chan mqtt.Message, opts collector.Options) prometheus.Collector {
+			return NewCollector(ctx, messageChan, Options{Options: opts})
+		},
+	})
 }
 
 func NewCollector(ctx context.Context, messageChan <-chan mqtt.Message, opts Options) *Collector {
@@ -50,7 +61,7 @@ func NewCollector(ctx context.Context, messageChan <-chan mqtt.Message, opts Opt
 		totalReturnedDesc:  prometheus.NewDesc("shelly3em_total_returned", "total energy returned to the grid in Wh (accumulated in device's non-volatile memory)", []string{"device", "phase"}, nil),
 		energyDesc:         prometheus.NewDesc("shelly3em_energy", "energy counter in Watt-minute since last report", []string{"device", "phase"}, nil),
 		energyReturnedDesc: prometheus.NewDesc("shelly3em_energy_returned", "energy returned to the grid in Watt-minute since last report", []string{"device", "phase"}, nil),
-		upDesc:             prometheus.NewDesc("shelly3em_up", "Whether scrape was successful", []string{"last_error"}, nil),
+		health:             collector.NewHealth("shelly3em", opts.Options),
 	}
 	_ = c.swapTopicValueCollector()
 
@@ -68,13 +79,18 @@ func NewCollector(ctx context.Context, messageChan <-chan mqtt.Message, opts Opt
 					continue
 				}
 
+				deviceID, _, _ := getMsgInfo(msg.Topic())
+				// the presence knows the ID from the topic, not the shortened one
+				c.health.SetID(deviceID, collector.DeviceFromTopic(msg.Topic()))
 				f64, _, err := byteconv.ParseFloat(msg.Payload())
 				if err != nil {
-					opts.Log.Error("failed to parse payload", zap.Error(err), zap.String("topic", msg.Topic()))
+					c.health.ParseError(deviceID, msg.Topic(), err)
 					continue
 				}
 
 				c.topicValueCollector[msg.Topic()] = f64
+				opts.Timestamps.Observe(deviceID, 0)
+				c.health.OK(deviceID)
 
 			case <-ctx.Done():
 				return
@@ -105,16 +121,12 @@ func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
 	ch <- c.totalReturnedDesc
 	ch <- c.energyDesc
 	ch <- c.energyReturnedDesc
-	ch <- c.upDesc
+	c.health.Describe(ch)
 }
 
 func (c *Collector) Collect(ch chan<- prometheus.Metric) {
-	if err := c.collect(ch); err == nil {
-		ch <- prometheus.MustNewConstMetric(c.upDesc, prometheus.GaugeValue, 1, "")
-	} else {
-		c.opts.Log.Error("Scrape failed", zap.Error(err))
-		ch <- prometheus.MustNewConstMetric(c.upDesc, prometheus.GaugeValue, 0, err.Error())
-	}
+	c.collect(ch)
+	c.health.Collect(ch)
 }
 
 func getMsgInfo(topic string) (deviceID, phaseID, lastPath string) {
@@ -125,10 +137,14 @@ func getMsgInfo(topic string) (deviceID, phaseID, lastPath string) {
 	return deviceID, topicPaths[len(topicPaths)-2], topicPaths[len(topicPaths)-1]
 }
 
-func (c *Collector) collect(ch chan<- prometheus.Metric) error {
+func (c *Collector) collect(ch chan<- prometheus.Metric) {
 	tv := c.swapTopicValueCollector()
 
 	for _, kv := range tv {
+		// the presence knows the ID from the topic, not the shortened one
+		if c.opts.Presence.Offline(strings.Split(kv.Key, "/")[1]) {
+			continue
+		}
 
 		deviceID, phaseID, lastPath := getMsgInfo(kv.Key)
 
@@ -153,5 +169,4 @@ func (c *Collector) collect(ch chan<- prometheus.Metric) error {
 			c.opts.Log.Warn("unhandled topic", zap.String("topic", kv.Key), zap.Float64("value", kv.Value))
 		}
 	}
-	return nil
 }
@@ -7,6 +7,8 @@ import (
 	"strings"
 	"testing"
 
+	"github.com/SchumacherFM/prometheus_shelly_exporter/collector"
+	"github.com/SchumacherFM/prometheus_shelly_exporter/collector/collectortest"
 	mqtt "github.com/eclipse/paho.mqtt.golang"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/testutil"
@@ -23,7 +25,7 @@ func TestCollector_collect(t *testing.T) {
 	log, _ := zap.NewDevelopment(zap.Development())
 	msgGoRoutineDone := make(chan struct{})
 	c := NewCollector(ctx, msgChan, Options{
-		Log: log,
+		Options: collector.Options{Log: log},
 		TestCB: func() {
 			close(msgGoRoutineDone)
 		},
@@ -38,10 +40,7 @@ func TestCollector_collect(t *testing.T) {
 	for s.Scan() {
 		lineParts := strings.Split(s.Text(), "+01:00:")
 		topicValue := strings.Split(lineParts[1], ":")
-		msgChan <- mockMsg{
-			topic:   topicValue[0],
-			payload: topicValue[1],
-		}
+		msgChan <- collectortest.NewMessage(topicValue[0], topicValue[1])
 	}
 	require.NoError(t, s.Err())
 	close(msgChan)
@@ -83,9 +82,14 @@ shelly3em_total{device="washtumbler",phase="2"} 17.9
 shelly3em_total_returned{device="washtumbler",phase="0"} 0
 shelly3em_total_returned{device="washtumbler",phase="1"} 0
 shelly3em_total_returned{device="washtumbler",phase="2"} 0.6
-# HELP shelly3em_up Whether scrape was successful
+# HELP shelly3em_scrape_errors_total readings of the device which failed
+# TYPE shelly3em_scrape_errors_total counter
+shelly3em_scrape_errors_total{device="washtumbler",reason="invalid_reading"} 0
+shelly3em_scrape_errors_total{device="washtumbler",reason="parse_error"} 1
+shelly3em_scrape_errors_total{device="washtumbler",reason="stale"} 0
+# HELP shelly3em_up 1 if the last reading of the device was fine
 # TYPE shelly3em_up gauge
-shelly3em_up{last_error=""} 1
+shelly3em_up{device="washtumbler"} 1
 # HELP shelly3em_voltage grid voltage in Volts
 # TYPE shelly3em_voltage gauge
 shelly3em_voltage{device="washtumbler",phase="0"} 231.39
@@ -101,42 +105,7 @@ shelly3em_voltage{device="washtumbler",phase="2"} 231.15
 		"shelly3em_energy",
 		"shelly3em_energy_returned",
 		"shelly3em_up",
+		"shelly3em_scrape_errors_total",
 	)
 	require.NoError(t, err)
 }
-
-type mockMsg struct {
-	topic   string
-	payload string
-}
-
-func (mockMsg) Duplicate() bool {
-	// TODO implement me
-	panic("implement me")
-}
-
-func (mockMsg) Qos() byte {
-	// TODO implement me
-	panic("implement me")
-}
-
-func (mockMsg) Retained() bool {
-	// TODO implement me
-	panic("implement me")
-}
-
-func (m mockMsg) Topic() string {
-	return m.topic
-}
-
-func (mockMsg) MessageID() uint16 {
-	// TODO implement me
-	panic("implement me")
-}
-
-func (m mockMsg) Payload() []byte {
-	return []byte(m.payload)
-}
-
-func (mockMsg) Ack() {
-}
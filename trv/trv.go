@@ -0,0 +1,194 @@
+package trv
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"sync"
+
+	"github.com/SchumacherFM/prometheus_shelly_exporter/collector"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/tidwall/gjson"
+	"go.uber.org/zap"
+)
+
+// Thermostat is one entry of thermostats in the info payload. The TRV has
+// exactly one.
+type Thermostat struct {
+	Pos     float64 `json:"pos"`
+	TargetT struct {
+		Enabled bool    `json:"enabled"`
+		Value   float64 `json:"value"`
+		Units   string  `json:"units"`
+	} `json:"target_t"`
+	Tmp struct {
+		Value   float64 `json:"value"`
+		Units   string  `json:"units"`
+		IsValid bool    `json:"is_valid"`
+	} `json:"tmp"`
+	Schedule        bool `json:"schedule"`
+	ScheduleProfile int  `json:"schedule_profile"`
+	BoostMinutes    int  `json:"boost_minutes"`
+	WindowOpen      bool `json:"window_open"`
+}
+
+// Info represents the minimal data of shellies/shellytrv-<id>/info.
+type Info struct {
+	Unixtime    int64        `json:"unixtime"`
+	Thermostats []Thermostat `json:"thermostats"`
+	Bat         struct {
+		Value   int     `json:"value"`
+		Voltage float64 `json:"voltage"`
+	} `json:"bat"`
+}
+
+type Collector struct {
+	opts                Options
+	posDesc             *prometheus.Desc
+	targetDesc          *prometheus.Desc
+	tmpDesc             *prometheus.Desc
+	windowOpenDesc      *prometheus.Desc
+	scheduleProfileDesc *prometheus.Desc
+	batDesc             *prometheus.Desc
+	health              *collector.Health
+
+	mu      sync.Mutex
+	devices map[string]Info // device ID => last info
+}
+
+type Options struct {
+	collector.Options
+	TestCB func()
+}
+
+func init() {
+	collector.Register(collector.Definition{
+		Name:          "trv",
+		Help:          "Shelly TRV",
+		Default:       true,
+		Subscriptions: []string{"shellies/+/info"},
+		New: func(ctx context.Context, messageChan <-chan mqtt.Message, opts collector.Options) prometheus.Collector {
+			return NewCollector(ctx, messageChan, Options{Options: opts})
+		},
+	})
+}
+
+func NewCollector(ctx context.Context, messageChan <-chan mqtt.Message, opts Options) *Collector {
+	c := &Collector{
+		opts:                opts,
+		posDesc:             prometheus.NewDesc("shellytrv_valve_position", "valve position in percent", []string{"device"}, nil),
+		targetDesc:          prometheus.NewDesc("shellytrv_target_temperature", "target temperature", []string{"device", "unit"}, nil),
+		tmpDesc:             prometheus.NewDesc("shellytrv_temperature", "measured temperature", []string{"device", "unit"}, nil),
+		windowOpenDesc:      prometheus.NewDesc("shellytrv_window_open", "1 if the TRV detected an open window", []string{"device"}, nil),
+		scheduleProfileDesc: prometheus.NewDesc("shellytrv_schedule_profile", "ID of the active schedule profile", []string{"device"}, nil),
+		batDesc:             prometheus.NewDesc("shellytrv_battery", "Sensor battery", []string{"device", "unit"}, nil),
+		health:              collector.NewHealth("shellytrv", opts.Options),
+		devices:             make(map[string]Info),
+	}
+
+	go func() {
+		for {
+			select {
+			case msg, ok := <-messageChan:
+				if !ok {
+					if opts.TestCB != nil {
+						opts.TestCB()
+					}
+					return
+				}
+				if false == strings.HasSuffix(msg.Topic(), "/info") {
+					continue
+				}
+
+				c.handle(msg.Topic(), msg.Payload())
+
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return c
+}
+
+func (c *Collector) handle(topic string, payload []byte) {
+	// shellies/shellytrv-<id>/info
+	topicPaths := strings.Split(topic, "/")
+	if len(topicPaths) != 3 {
+		return
+	}
+
+	// info of another device type, e.g. H&T, is none of our business even if
+	// it is broken
+	devID := topicPaths[1]
+	if !strings.HasPrefix(devID, "shellytrv-") && !gjson.GetBytes(payload, "thermostats").Exists() {
+		return
+	}
+
+	var info Info
+	if err := json.Unmarshal(payload, &info); err != nil {
+		c.health.ParseError(devID, topic, err)
+		return
+	}
+	if len(info.Thermostats) == 0 {
+		// e.g. a partial update without the thermostat
+		return
+	}
+
+	c.opts.Log.Debug("message from mqtt",
+		zap.String("topic", topic),
+		zap.Int("length", len(payload)))
+
+	c.opts.Timestamps.Observe(devID, float64(info.Unixtime))
+	c.health.OK(devID)
+
+	c.mu.Lock()
+	c.devices[devID] = info
+	c.mu.Unlock()
+}
+
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.posDesc
+	ch <- c.targetDesc
+	ch <- c.tmpDesc
+	ch <- c.windowOpenDesc
+	ch <- c.scheduleProfileDesc
+	ch <- c.batDesc
+	c.health.Describe(ch)
+}
+
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	c.collect(ch)
+	c.health.Collect(ch)
+}
+
+func boolToFloat(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func (c *Collector) collect(ch chan<- prometheus.Metric) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for devID, info := range c.devices {
+		if c.opts.Presence.Offline(devID) {
+			continue
+		}
+		t := info.Thermostats[0]
+		ch <- prometheus.MustNewConstMetric(c.posDesc, prometheus.GaugeValue, t.Pos, devID)
+		if t.TargetT.Enabled {
+			ch <- prometheus.MustNewConstMetric(c.targetDesc, prometheus.GaugeValue, t.TargetT.Value, devID, strings.ToLower(t.TargetT.Units))
+		}
+		if t.Tmp.IsValid {
+			ch <- prometheus.MustNewConstMetric(c.tmpDesc, prometheus.GaugeValue, t.Tmp.Value, devID, strings.ToLower(t.Tmp.Units))
+		}
+		ch <- prometheus.MustNewConstMetric(c.windowOpenDesc, prometheus.GaugeValue, boolToFloat(t.WindowOpen), devID)
+		ch <- prometheus.MustNewConstMetric(c.scheduleProfileDesc, prometheus.GaugeValue, float64(t.ScheduleProfile), devID)
+		ch <- prometheus.MustNewConstMetric(c.batDesc, prometheus.GaugeValue, info.Bat.Voltage, devID, "V")
+		ch <- prometheus.MustNewConstMetric(c.batDesc, prometheus.GaugeValue, float64(info.Bat.Value), devID, "%")
+	}
+}
@@ -0,0 +1,76 @@
+package trv
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/SchumacherFM/prometheus_shelly_exporter/collector"
+	"github.com/SchumacherFM/prometheus_shelly_exporter/collector/collectortest"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+var _ prometheus.Collector = (*Collector)(nil)
+
+func TestCollector_collect(t *testing.T) {
+	ctx := context.Background()
+	msgChan := make(chan mqtt.Message)
+
+	log, _ := zap.NewDevelopment(zap.Development())
+	msgGoRoutineDone := make(chan struct{})
+	c := NewCollector(ctx, msgChan, Options{
+		Options: collector.Options{Log: log},
+		TestCB: func() {
+			close(msgGoRoutineDone)
+		},
+	})
+
+	collectortest.SendFile(t, msgChan, "testdata/trv.txt")
+	close(msgChan)
+	<-msgGoRoutineDone
+
+	// the broken info of the H&T is none of the TRV collector's business
+	err := testutil.CollectAndCompare(c, strings.NewReader(`
+# HELP shellytrv_battery Sensor battery
+# TYPE shellytrv_battery gauge
+shellytrv_battery{device="shellytrv-8CF681A1B2C3",unit="%"} 85
+shellytrv_battery{device="shellytrv-8CF681A1B2C3",unit="V"} 3.86
+# HELP shellytrv_schedule_profile ID of the active schedule profile
+# TYPE shellytrv_schedule_profile gauge
+shellytrv_schedule_profile{device="shellytrv-8CF681A1B2C3"} 2
+# HELP shellytrv_scrape_errors_total readings of the device which failed
+# TYPE shellytrv_scrape_errors_total counter
+shellytrv_scrape_errors_total{device="shellytrv-8CF681A1B2C3",reason="invalid_reading"} 0
+shellytrv_scrape_errors_total{device="shellytrv-8CF681A1B2C3",reason="parse_error"} 1
+shellytrv_scrape_errors_total{device="shellytrv-8CF681A1B2C3",reason="stale"} 0
+# HELP shellytrv_target_temperature target temperature
+# TYPE shellytrv_target_temperature gauge
+shellytrv_target_temperature{device="shellytrv-8CF681A1B2C3",unit="c"} 21
+# HELP shellytrv_temperature measured temperature
+# TYPE shellytrv_temperature gauge
+shellytrv_temperature{device="shellytrv-8CF681A1B2C3",unit="c"} 17.1
+# HELP shellytrv_up 1 if the last reading of the device was fine
+# TYPE shellytrv_up gauge
+shellytrv_up{device="shellytrv-8CF681A1B2C3"} 1
+# HELP shellytrv_valve_position valve position in percent
+# TYPE shellytrv_valve_position gauge
+shellytrv_valve_position{device="shellytrv-8CF681A1B2C3"} 0
+# HELP shellytrv_window_open 1 if the TRV detected an open window
+# TYPE shellytrv_window_open gauge
+shellytrv_window_open{device="shellytrv-8CF681A1B2C3"} 1
+`),
+		"shellytrv_battery",
+		"shellytrv_schedule_profile",
+		"shellytrv_scrape_errors_total",
+		"shellytrv_target_temperature",
+		"shellytrv_temperature",
+		"shellytrv_up",
+		"shellytrv_valve_position",
+		"shellytrv_window_open",
+	)
+	require.NoError(t, err)
+}